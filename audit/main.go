@@ -0,0 +1,272 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"laby/game"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// lobbySession collects the events outside of any session: connects,
+// resumes and leaves while players wait for a partner.
+const lobbySession = "lobby"
+
+// Session collects what happened in one session of the audit log.
+type Session struct {
+	id      string
+	start   time.Time
+	end     time.Time
+	ended   bool
+	players map[game.Player]string
+
+	accepted map[string]int // by nick
+	denied   map[string]int // by nick
+	reasons  map[string]int
+
+	triggers  int
+	doors     map[int]bool // opened at least once
+	plates    map[int]bool
+	rooms     map[int]bool
+	blocked   int
+	undos     int
+	restarts  int
+	completed []game.Tick // the level was completed, again after an undo or restart
+	connects  int
+	resumes   int
+	drops     int
+	leaves    int
+	lastEvent game.AuditEvent
+}
+
+func NewSession(id string) *Session {
+	return &Session{
+		id:       id,
+		players:  make(map[game.Player]string),
+		accepted: make(map[string]int),
+		denied:   make(map[string]int),
+		reasons:  make(map[string]int),
+		doors:    make(map[int]bool),
+		plates:   make(map[int]bool),
+		rooms:    make(map[int]bool),
+	}
+}
+
+func (s *Session) Add(event game.AuditEvent) {
+	if s.start.IsZero() {
+		s.start = event.Time
+	}
+	s.end = event.Time
+	s.lastEvent = event
+
+	switch event.Kind {
+	case game.AuditConnect:
+		s.connects++
+	case game.AuditResume:
+		s.resumes++
+	case game.AuditLeave:
+		s.leaves++
+	}
+
+	switch event.Kind {
+	case game.AuditRole, game.AuditConnect, game.AuditResume:
+		if event.Player != nil && *event.Player >= 0 {
+			s.players[*event.Player] = event.Nick
+		}
+	case game.AuditSessionEnd:
+		s.ended = true
+	case game.AuditAction:
+		if event.Result == game.AuditAccepted {
+			s.accepted[event.Nick]++
+		} else {
+			s.denied[event.Nick]++
+			s.reasons[event.Reason]++
+		}
+	case game.EventTrigger:
+		s.triggers++
+	case game.EventDoor:
+		if event.Active {
+			s.doors[event.Id] = true
+		}
+	case game.EventPlate:
+		s.plates[event.Id] = true
+	case game.EventRoom:
+		s.rooms[event.Id] = true
+	case game.EventBlocked:
+		s.blocked++
+	case game.EventLevelCompleted:
+		s.completed = append(s.completed, event.Tick)
+	case game.AuditUndo:
+		s.undos++
+	case game.AuditRestart:
+		s.restarts++
+	case game.AuditDisconnect:
+		s.drops++
+	}
+}
+
+func sum(counts map[string]int) int {
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	return total
+}
+
+// byCount lists the keys with the most frequent first.
+func byCount(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key, _ := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+func (s *Session) printConnections() {
+	fmt.Printf("  connects %d, resumes %d, disconnects %d, leaves %d\n",
+		s.connects, s.resumes, s.drops, s.leaves)
+}
+
+func (s *Session) Print() {
+	if s.id == lobbySession {
+		fmt.Printf("lobby: %s to %s\n", s.start.Format("2006-01-02 15:04:05"),
+			s.end.Format("2006-01-02 15:04:05"))
+		s.printConnections()
+		return
+	}
+
+	state := "not ended"
+	if s.ended {
+		state = "ended"
+	}
+	fmt.Printf("session %s: %s, %v, %s\n", s.id, s.start.Format("2006-01-02 15:04:05"),
+		s.end.Sub(s.start).Truncate(time.Second), state)
+
+	roles := make([]string, 0, len(s.players))
+	for player := game.Human; player <= game.Ghost; player++ {
+		if nick, ok := s.players[player]; ok {
+			roles = append(roles, nick+" ("+game.RoleName(player)+")")
+		}
+	}
+	fmt.Printf("  players: %s\n", strings.Join(roles, ", "))
+
+	fmt.Printf("  actions: %d accepted, %d denied\n", sum(s.accepted), sum(s.denied))
+	for _, nick := range byCount(s.accepted) {
+		fmt.Printf("    %s: %d accepted, %d denied\n", nick, s.accepted[nick], s.denied[nick])
+	}
+	reasons := make([]string, 0, len(s.reasons))
+	for _, reason := range byCount(s.reasons) {
+		reasons = append(reasons, fmt.Sprintf("%s %d", reason, s.reasons[reason]))
+	}
+	if len(reasons) > 0 {
+		fmt.Printf("    denied: %s\n", strings.Join(reasons, ", "))
+	}
+
+	fmt.Printf("  levers pulled %d, doors opened %d, plates pressed %d, rooms revealed %d, moves blocked %d\n",
+		s.triggers, len(s.doors), len(s.plates), len(s.rooms), s.blocked)
+	fmt.Printf("  undos %d, restarts %d, last tick %d\n", s.undos, s.restarts, s.lastEvent.Tick)
+	if len(s.completed) == 0 {
+		fmt.Printf("  level not completed\n")
+	}
+	for _, tick := range s.completed {
+		fmt.Printf("  level completed at tick %d\n", tick)
+	}
+	s.printConnections()
+}
+
+func describe(event game.AuditEvent) string {
+	parts := []string{event.Time.Format("15:04:05.000"), fmt.Sprintf("%6d", event.Tick), event.Kind}
+	if event.Player != nil {
+		who := game.RoleName(*event.Player)
+		if event.Nick != "" {
+			who = event.Nick + " (" + who + ")"
+		}
+		parts = append(parts, who)
+	}
+	if event.Action != "" {
+		parts = append(parts, event.Action, event.Result)
+	}
+	if event.Kind == game.EventBlocked {
+		parts = append(parts, game.DenyReason(event.Id).String())
+	} else if event.Id != 0 {
+		parts = append(parts, fmt.Sprintf("#%d", event.Id))
+	}
+	if event.Kind == game.EventDoor || event.Kind == game.EventTrigger {
+		if event.Active {
+			parts = append(parts, "on")
+		} else {
+			parts = append(parts, "off")
+		}
+	}
+	if event.Addr != "" {
+		parts = append(parts, event.Addr)
+	}
+	if event.Reason != "" {
+		parts = append(parts, "("+event.Reason+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+func main() {
+	dir := flag.String("dir", "audits", "directory of the audit log, used if no files are given")
+	sessionId := flag.String("session", "", "only this session")
+	events := flag.Bool("events", false, "list the events instead of summing them up")
+	flag.Parse()
+
+	files := flag.Args()
+	if len(files) == 0 {
+		// the rotated files sort before the current one
+		var err error
+		if files, err = filepath.Glob(filepath.Join(*dir, "audit*.jsonl")); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		sort.Strings(files)
+	}
+
+	sessions := make(map[string]*Session)
+	order := make([]string, 0)
+	for _, file := range files {
+		auditEvents, err := game.ReadAuditLogFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to read", file, err)
+			os.Exit(1)
+		}
+
+		for _, event := range auditEvents {
+			id := event.Session
+			if id == "" {
+				id = lobbySession
+			}
+			if *sessionId != "" && id != *sessionId {
+				continue
+			}
+
+			if *events {
+				fmt.Println(id, describe(event))
+				continue
+			}
+
+			session, ok := sessions[id]
+			if !ok {
+				session = NewSession(id)
+				sessions[id] = session
+				order = append(order, id)
+			}
+			session.Add(event)
+		}
+	}
+
+	for _, id := range order {
+		sessions[id].Print()
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"github.com/banthar/Go-SDL/sdl"
+	"laby/game"
+	"time"
+)
+
+const (
+	chatLineTime   = 10 * time.Second
+	maxChatLines   = 5
+	markerTime     = 4 * time.Second
+	messagesPeriod = 200 * time.Millisecond
+)
+
+// Canned messages on the number keys, for when there is no time to type.
+var quickChat = []string{
+	"Over here!",
+	"Wait for me",
+	"Go ahead",
+	"Step on the plate",
+	"Pull the lever",
+}
+
+type chatLine struct {
+	text     string
+	received time.Time
+}
+
+// Marker is a map ping, RenderMap draws it until it expires.
+type Marker struct {
+	pos     game.MapPosition
+	from    game.Player
+	created time.Time
+}
+
+type ChatState struct {
+	typing  bool
+	input   []rune
+	lines   []chatLine
+	markers []Marker
+	outbox  []game.Message
+
+	lastPoll time.Time
+}
+
+func NewChatState() *ChatState {
+	return &ChatState{
+		typing:  false,
+		input:   make([]rune, 0),
+		lines:   make([]chatLine, 0),
+		markers: make([]Marker, 0),
+		outbox:  make([]game.Message, 0),
+	}
+}
+
+func (cs *ChatState) IsTyping() bool {
+	return cs.typing
+}
+
+// HandleKey returns true if the key was used by the chat and must not reach
+// the game input. Key releases are never used, held keys must be able to go
+// up while typing.
+func (cs *ChatState) HandleKey(e *sdl.KeyboardEvent) bool {
+	if e.Type != sdl.KEYDOWN {
+		return false
+	}
+
+	if !cs.typing {
+		switch {
+		case e.Keysym.Sym == sdl.K_t:
+			cs.typing = true
+			cs.input = cs.input[:0]
+			return true
+		case e.Keysym.Sym >= sdl.K_1 && e.Keysym.Sym < sdl.K_1+uint32(len(quickChat)):
+			cs.Say(quickChat[e.Keysym.Sym-sdl.K_1])
+			return true
+		}
+		return false
+	}
+
+	switch e.Keysym.Sym {
+	case sdl.K_ESCAPE:
+		cs.typing = false
+	case sdl.K_RETURN, sdl.K_KP_ENTER:
+		cs.typing = false
+		cs.Say(string(cs.input))
+	case sdl.K_BACKSPACE:
+		if len(cs.input) > 0 {
+			cs.input = cs.input[:len(cs.input)-1]
+		}
+	default:
+		r := rune(e.Keysym.Unicode)
+		if r != 0 && game.CanAppendChat(len(cs.input), r) {
+			cs.input = append(cs.input, r)
+		}
+	}
+	return true
+}
+
+// HandleClick pings the map cell under the mouse.
+func (cs *ChatState) HandleClick(e *sdl.MouseButtonEvent, g *game.Game) {
+	if e.Type != sdl.MOUSEBUTTONDOWN || e.Button != sdl.BUTTON_LEFT {
+		return
+	}
+
+	x := int(float32(e.X) / tileSize)
+	y := int(float32(e.Y) / tileSize)
+	if x >= g.Width() || y >= g.Height() {
+		return
+	}
+
+	cs.outbox = append(cs.outbox, game.Message{Kind: game.MessageMarker, X: x, Y: y})
+}
+
+func (cs *ChatState) Say(text string) {
+	text, err := game.CleanChatText(text)
+	if err != nil {
+		return
+	}
+	cs.outbox = append(cs.outbox, game.Message{Kind: game.MessageChat, Text: text})
+}
+
+// Notice shows a line from the game itself.
+func (cs *ChatState) Notice(text string) {
+	cs.receive(game.Message{Kind: game.MessageChat, Nick: "*", Text: text})
+}
+
+func (cs *ChatState) receive(msg game.Message) {
+	switch msg.Kind {
+	case game.MessageChat:
+		cs.lines = append(cs.lines, chatLine{msg.Nick + ": " + msg.Text, time.Now()})
+		if len(cs.lines) > maxChatLines {
+			cs.lines = cs.lines[len(cs.lines)-maxChatLines:]
+		}
+	case game.MessageMarker:
+		cs.markers = append(cs.markers, Marker{game.NewMapPosition(msg.X, msg.Y), msg.From, time.Now()})
+	}
+}
+
+// Exchange sends the queued messages and polls the server for new ones.
+func (cs *ChatState) Exchange(sc *ServerConn) error {
+	for len(cs.outbox) > 0 {
+		msg := cs.outbox[0]
+		cs.outbox = cs.outbox[1:]
+
+		resp, err := sc.SendMessage(msg)
+		if err != nil {
+			return err
+		}
+		if resp != game.ServerActionOk {
+			netLog.Debug("Message not accepted by server")
+		}
+	}
+
+	if time.Since(cs.lastPoll) < messagesPeriod {
+		return nil
+	}
+	cs.lastPoll = time.Now()
+
+	messages, err := sc.RequestMessages()
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		cs.receive(msg)
+	}
+	return nil
+}
+
+// Markers returns the pings that have not expired yet.
+func (cs *ChatState) Markers() []Marker {
+	markers := cs.markers[:0]
+	for _, marker := range cs.markers {
+		if time.Since(marker.created) < markerTime {
+			markers = append(markers, marker)
+		}
+	}
+	cs.markers = markers
+	return markers
+}
+
+// Draw shows the recent chat lines above the roster and the line being typed.
+func (cs *ChatState) Draw(text *TextRenderer) {
+	y := float32(screenHeight - 34 - 30)
+	if cs.typing {
+		text.Draw("Say: "+string(cs.input)+"_", 10, y)
+		y -= 30
+	}
+
+	for i := len(cs.lines) - 1; i >= 0; i-- {
+		if time.Since(cs.lines[i].received) > chatLineTime {
+			break
+		}
+		text.Draw(cs.lines[i].text, 10, y)
+		y -= 30
+	}
+}
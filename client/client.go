@@ -4,7 +4,6 @@
 package main
 
 import (
-	"encoding/gob"
 	// "fmt"
 	"github.com/banthar/Go-SDL/mixer"
 	"github.com/banthar/Go-SDL/sdl"
@@ -13,9 +12,9 @@ import (
 	"github.com/banthar/gl"
 	"go/build"
 	"laby/game"
-	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"runtime"
 	"sync"
 	"time"
@@ -63,28 +62,85 @@ func PollEvents() []sdl.Event {
 }
 
 func main() {
-	log.SetFlags(log.Llongfile)
 	runtime.LockOSThread()
 
-	conn, err := net.Dial("tcp", "129.27.19.194:8001")
+	cfg, err := ParseConfig()
 	if err != nil {
-		log.Fatal("No connection to server")
-		return
+		gameLog.Fatal("Failed to read config", "err", err)
+	}
+	if err := game.SetLogLevels(cfg.LogLevel); err != nil {
+		gameLog.Fatal("Failed to set log levels", "err", err)
+	}
+
+	// the client changes into its data directory later on
+	keysPath, err := filepath.Abs(cfg.Keys)
+	if err != nil {
+		gameLog.Fatal("Failed to find key bindings", "file", cfg.Keys, "err", err)
+	}
+	bindings, err := game.LoadBindings(keysPath)
+	if err != nil {
+		gameLog.Fatal("Failed to read key bindings", "file", keysPath, "err", err)
+	}
+
+	// the client has no level of its own, it comes with the replay or from
+	// the server
+	var level *game.Level
+	var replay *game.Replay
+	var sc *ServerConn
+	if cfg.Replay != "" {
+		if replay, err = game.ReadReplayFile(cfg.Replay); err != nil {
+			gameLog.Fatal("Failed to read replay", "err", err)
+		}
+		level = replay.Header.Level
+	} else if cfg.Local {
+		if level, err = game.ReadLevelFile(cfg.Level); err != nil {
+			gameLog.Fatal("Failed to read level", "err", err)
+		}
+	} else {
+		serverAddr := cfg.ServerAddr()
+		if cfg.Discover {
+			if serverAddr, err = PickServer(cfg); err != nil {
+				netLog.Fatal("LAN discovery failed", "err", err)
+			}
+		}
+
+		join := game.JoinRequest{
+			Spectator: cfg.Spectate,
+			Nick:      cfg.Name,
+			PlayerId:  cfg.PlayerId,
+		}
+
+		sc, err = DialServer(serverAddr, join, cfg.ServerTimeout())
+		if err != nil {
+			netLog.Fatal("No connection to server", "err", err)
+			return
+		}
+		level = sc.Level()
 	}
 
 	if sdl.Init(sdl.INIT_EVERYTHING) != 0 {
-		log.Fatal(sdl.GetError())
+		renderLog.Fatal("Failed to initialize SDL", "err", sdl.GetError())
 	}
 
-	var screen = sdl.SetVideoMode(screenWidth, screenHeight, 32, sdl.OPENGL|sdl.HWSURFACE|sdl.GL_DOUBLEBUFFER)
+	// split screen shows both players side by side
+	width := screenWidth
+	if cfg.Local {
+		width *= 2
+	}
+
+	var screen = sdl.SetVideoMode(width, screenHeight, 32, sdl.OPENGL|sdl.HWSURFACE|sdl.GL_DOUBLEBUFFER)
 	if screen == nil {
-		log.Fatal(sdl.GetError())
+		renderLog.Fatal("Failed to open the window", "err", sdl.GetError())
 	}
 
-	sdl.WM_SetCaption("Lecture Hall Games", "")
+	caption := "Lecture Hall Games"
+	if cfg.Name != "" {
+		caption += " - " + cfg.Name
+	}
+	sdl.WM_SetCaption(caption, "")
 	sdl.EnableUNICODE(1)
 	if gl.Init() != 0 {
-		log.Fatal("could not initialize OpenGL")
+		renderLog.Fatal("Could not initialize OpenGL")
 	}
 
 	gl.Viewport(0, 0, int(screen.W), int(screen.H))
@@ -99,17 +155,22 @@ func main() {
 
 	if mixer.OpenAudio(mixer.DEFAULT_FREQUENCY, mixer.DEFAULT_FORMAT,
 		mixer.DEFAULT_CHANNELS, 4096) != 0 {
-		log.Fatal(sdl.GetError())
+		audioLog.Fatal("Failed to open audio", "err", sdl.GetError())
 	}
 
 	if ttf.Init() != 0 {
-		log.Fatal(sdl.GetError())
+		renderLog.Fatal("Failed to initialize fonts", "err", sdl.GetError())
 	}
 
 	if p, err := build.Default.Import(basePkg, "", build.FindOnly); err == nil {
 		os.Chdir(p.Dir)
 	}
 
+	// the level's sounds are in the data directory
+	if err := game.LoadLevel(level); err != nil {
+		gameLog.Fatal("Failed to load level", "err", err)
+	}
+
 	// rand.Seed(time.Now().UnixNano())
 	// levelDir := fmt.Sprintf("data/levels/demolevel%d", 3+rand.Intn(numberLevels))
 	//carsDir := fmt.Sprintf(" data/cars/car%d/", 1+rand.Intn(numberCars))
@@ -120,23 +181,59 @@ func main() {
 	running := true
 	last := time.Now()
 
-	enc := gob.NewEncoder(conn)
-	dec := gob.NewDecoder(conn)
-
-	var player game.Player
-	dec.Decode(&player)
-	log.Println("Received player self")
+	// sdl.Quit closes them
+	OpenGamepads()
 
 	renderData := LoadRenderData()
-	clientGame, _ := game.NewGame()
-	// get player id from server
-	clientGame.NewPlayer(int(player))
+	text := NewTextRenderer("data/font.otf", 24)
 
-	is := game.NewInputState(clientGame, player)
+	if replay != nil {
+		RunReplay(replay, renderData, text)
+		sdl.Quit()
+		return
+	}
+
+	if cfg.Local {
+		RunLocal(renderData, text, bindings, keysPath)
+		sdl.Quit()
+		return
+	}
+
+	if sc.IsSpectator() {
+		RunSpectator(sc, renderData, text)
+		sdl.Quit()
+		return
+	}
+
+	player := sc.Player()
+	logAsPlayer(sc.Nick(), player)
+
+	var clientGame *game.Game
+	if sc.Fog() {
+		// the server hides the map, everything comes with the views
+		view, _, err := sc.RequestView()
+		if err != nil {
+			netLog.Fatal("Failed to get the view", "err", err)
+		}
+		clientGame = game.NewViewGame(view)
+	} else {
+		clientGame, _ = game.NewGame()
+		clientGame.RecordEvents()
+		// get player id from server
+		clientGame.NewPlayer(int(player))
+	}
+
+	is := game.NewInputStateWithBindings(clientGame, player, bindings.Online, bindings.Gamepad)
+	keys := NewKeyScreen(bindings, keysPath, false)
+	chat := NewChatState()
+	feedback := NewFeedback(player)
 
 	gameStarted := false
+	status := game.SessionRunning
+	saveRequested := false
+	votes := make([]game.VoteKind, 0)
 
-	log.Println("We are", player)
+	netLog.Info("Joined the session")
 
 	// var music *mixer.Music
 	// var font *ttf.Font
@@ -162,10 +259,31 @@ func main() {
 				running = false
 			case *sdl.ResizeEvent:
 				screen = sdl.SetVideoMode(int(e.W), int(e.H), 32, sdl.RESIZABLE)
+			case *sdl.MouseButtonEvent:
+				chat.HandleClick(e, clientGame)
+			case *sdl.JoyAxisEvent, *sdl.JoyHatEvent, *sdl.JoyButtonEvent:
+				if !keys.IsOpen() {
+					HandleGamepad(is, e)
+				}
 			case *sdl.KeyboardEvent:
+				if keys.HandleKey(e) {
+					if keys.Changed() {
+						is.SetKeyMap(bindings.Online)
+					}
+					continue
+				}
+				if chat.HandleKey(e) {
+					continue
+				}
 				if e.Type == sdl.KEYDOWN {
 					if e.Keysym.Sym == sdl.K_ESCAPE {
 						running = false
+					} else if e.Keysym.Sym == sdl.K_F5 {
+						saveRequested = true
+					} else if e.Keysym.Sym == sdl.K_F6 {
+						votes = append(votes, game.VoteUndo)
+					} else if e.Keysym.Sym == sdl.K_F9 {
+						votes = append(votes, game.VoteRestart)
 					} else {
 						// game.KeyPressed(e.Keysym)
 					}
@@ -184,7 +302,33 @@ func main() {
 		// handle user input
 		playerActions := is.StepActions(t)
 
-		clientGame.Update(t)
+		if sc.IsLost() {
+			if err := sc.Reconnect(); err != nil {
+				netLog.Fatal("Lost connection to server", "err", err)
+			}
+		}
+
+		if sc.IsLost() {
+			RenderMap(player, renderData, clientGame, chat.Markers(), 0)
+			text.Draw("Connection lost, reconnecting...", 10, 10)
+			sdl.GL_SwapBuffers()
+			continue
+		}
+
+		if err := sc.Heartbeat(); err != nil {
+			sc.ConnectionLost(err)
+			continue
+		}
+
+		if err := sc.RefreshRoster(); err != nil {
+			sc.ConnectionLost(err)
+			continue
+		}
+
+		if err := chat.Exchange(sc); err != nil {
+			sc.ConnectionLost(err)
+			continue
+		}
 
 		if len(playerActions) > 1 {
 			// log.Fatal("Sending multiple actions not supported")
@@ -192,75 +336,192 @@ func main() {
 
 		if !gameStarted {
 			// log.Println("Request game state")
-			var otherPlayer game.Player
-			var otherPlayerJoined bool
-			var gameStartsNow bool
-
-			enc.Encode(game.ClientReqGameState)
-			dec.Decode(&otherPlayerJoined)
+			otherPlayerJoined, otherPlayer, gameStartsNow, err := sc.RequestGameState()
+			if err != nil {
+				sc.ConnectionLost(err)
+				continue
+			}
 			if otherPlayerJoined {
-				dec.Decode(&otherPlayer)
-				dec.Decode(&gameStartsNow)
-				clientGame.NewPlayer(int(otherPlayer))
-				log.Println("Added other player")
+				if !sc.Fog() {
+					clientGame.NewPlayer(int(otherPlayer))
+				}
+				gameLog.Info("Partner joined", "partner", game.RoleName(otherPlayer))
 				gameStarted = gameStartsNow
 			}
+
+			// the server may have resumed a saved session
+			if gameStarted && !sc.Fog() {
+				snapshot, _, err := sc.RequestSnapshot()
+				if err != nil {
+					sc.ConnectionLost(err)
+					continue
+				}
+				if err := clientGame.RestoreSnapshot(snapshot); err != nil {
+					gameLog.Error("Failed to restore snapshot", "err", err)
+				}
+			}
+		}
+
+		if saveRequested {
+			saveRequested = false
+			serverResp, reason, err := sc.RequestSave()
+			if err != nil {
+				sc.ConnectionLost(err)
+				continue
+			}
+			switch {
+			case serverResp == game.ServerActionOk:
+				chat.Notice("Game saved")
+			case reason == game.DenyBotPartner:
+				chat.Notice("Games with the bot are not saved")
+			case reason == game.DenyNoPlayerId:
+				chat.Notice("The game could not be saved, a player has no id")
+			default:
+				chat.Notice("The game could not be saved")
+			}
+		}
+
+		for _, kind := range votes {
+			serverResp, err := sc.RequestVote(kind)
+			if err != nil {
+				sc.ConnectionLost(err)
+				break
+			}
+			if serverResp != game.ServerActionOk {
+				chat.Notice("Not possible right now")
+			}
+		}
+		votes = votes[:0]
+		if sc.IsLost() {
+			continue
 		}
 
 		// send user input to server
 
 		// log.Println("Send new actions")
-		filteredActions := make([]game.ActionType, 0)
+		filteredActions := make([]game.TickAction, 0)
 		for _, action := range playerActions {
-			enc.Encode(game.ClientReqSendAction)
-			enc.Encode(1)
-			enc.Encode(action)
-
-			var serverResp game.ServerResponse
-			dec.Decode(&serverResp)
-			if serverResp != game.ServerActionOk {
-				log.Println("server action not ok", serverResp)
+			serverResp, tick, reason, err := sc.SendAction(action)
+			if err != nil {
+				sc.ConnectionLost(err)
+				break
+			}
+			if serverResp == game.ServerActionDenied {
+				netLog.Debug("Action denied", "action", action, "reason", reason)
+				feedback.Denied(reason)
+			} else if serverResp != game.ServerActionOk {
+				netLog.Debug("Action not accepted", "action", action, "response", serverResp)
 			} else {
-				filteredActions = append(filteredActions, action)
+				filteredActions = append(filteredActions, game.TickAction{Tick: tick, Player: player, Action: action})
 			}
 		}
+		if sc.IsLost() {
+			continue
+		}
 
-		// now fetch input from other users
-
-		// log.Println("Requesting client update")
-		enc.Encode(game.ClientReqUpdate)
-		var numPlayers int
-		var numActions int
-		var otherPlayer game.Player
-		var action game.ActionType
-		dec.Decode(&numPlayers)
-
-		var data map[game.Player][]game.ActionType = make(map[game.Player][]game.ActionType, 0)
-		for i := 0; i < numPlayers; i++ {
-			dec.Decode(&otherPlayer)
-			data[otherPlayer] = make([]game.ActionType, 0)
-
-			dec.Decode(&numActions)
-			for j := 0; j < numActions; j++ {
-				dec.Decode(&action)
-				log.Println("Received action", otherPlayer, action)
-				data[otherPlayer] = append(data[otherPlayer], action)
+		if sc.Fog() {
+			view, newStatus, err := sc.RequestView()
+			if err != nil {
+				sc.ConnectionLost(err)
+				continue
+			}
+			if newStatus == game.SessionReset {
+				gameLog.Info("Partner left, waiting for a new one")
+				gameStarted = false
+			}
+			if newStatus == game.SessionReset || newStatus == game.SessionRewound {
+				newStatus = game.SessionRunning
+			}
+			status = newStatus
+			clientGame.ApplyView(view)
+			for _, reason := range view.Blocked {
+				feedback.Denied(reason)
+			}
+		} else {
+			// now fetch input from other users
+
+			// log.Println("Requesting client update")
+			update, err := sc.RequestUpdate()
+			if err != nil {
+				sc.ConnectionLost(err)
+				continue
+			}
+			data, newStatus := update.Actions, update.Status
+
+			if newStatus == game.SessionReset {
+				gameLog.Info("Partner left, waiting for a new one")
+				clientGame, _ = game.NewGame()
+				clientGame.RecordEvents()
+				clientGame.NewPlayer(int(player))
+				is = game.NewInputStateWithBindings(clientGame, player, bindings.Online, bindings.Gamepad)
+				gameStarted = false
+				data = make(map[game.Player][]game.TickAction, 0)
+				filteredActions = make([]game.TickAction, 0)
+				newStatus = game.SessionRunning
+			}
+
+			// undo or restart, the snapshot already has every action performed
+			// so far
+			if newStatus == game.SessionRewound {
+				snapshot, _, err := sc.RequestSnapshot()
+				if err != nil {
+					sc.ConnectionLost(err)
+					continue
+				}
+				if err := clientGame.RestoreSnapshot(snapshot); err != nil {
+					gameLog.Error("Failed to restore snapshot", "err", err)
+				}
+				data = make(map[game.Player][]game.TickAction, 0)
+				filteredActions = make([]game.TickAction, 0)
+				newStatus = game.SessionRunning
+			}
+			status = newStatus
+
+			// actions are performed on the ticks the server performed them on,
+			// the game never runs ahead of the server
+			data[player] = filteredActions
+			for thePlayer, actions := range data {
+				for _, action := range actions {
+					gameLog.Debug("Scheduling action", "from", thePlayer, "action", action.Action, "tick", action.Tick)
+					if err := clientGame.ScheduleAction(action.Tick, thePlayer, action.Action); err != nil {
+						gameLog.Warn("Failed to schedule action", "from", thePlayer, "action", action.Action, "tick", action.Tick, "err", err)
+					}
+				}
+			}
+
+			if status == game.SessionRunning && update.HashTick > 0 && update.HashTick >= clientGame.Tick() {
+				clientGame.StepTo(update.HashTick)
+				snapshot, err := sc.SendStateHash(game.StateHash{Tick: update.HashTick, Hash: clientGame.StateHash()})
+				if err != nil {
+					sc.ConnectionLost(err)
+					continue
+				}
+				if snapshot != nil {
+					gameLog.Warn("Out of sync with the server, resyncing", "tick", update.HashTick)
+					if err := clientGame.RestoreSnapshot(snapshot); err != nil {
+						gameLog.Error("Failed to restore snapshot", "err", err)
+					}
+				}
 			}
-		}
 
-		data[player] = filteredActions
-		for thePlayer, actions := range data {
-			for _, action := range actions {
-				log.Println("Perform action from player", thePlayer, action)
-				err := clientGame.PerformPlayerAction(thePlayer, action)
-				log.Println(err)
+			if status == game.SessionRunning {
+				clientGame.StepTo(update.Tick)
 			}
+			feedback.Blocked(clientGame.TakeEvents())
 		}
-		RenderMap(player, renderData, clientGame)
+		RenderMap(player, renderData, clientGame, chat.Markers(), feedback.Shake())
 
 		if !gameStarted {
 			// spriteStart.Draw(50, 50, 0, 1, true)
+			text.Draw("Waiting for partner...", 10, 10)
+		} else if status == game.SessionPartnerDisconnected {
+			text.Draw("Partner left, waiting for them to come back...", 10, 10)
 		}
+		DrawLatency(text, sc.Latency())
+		DrawRoster(text, sc.Roster(), player)
+		chat.Draw(text)
+		feedback.Draw(text)
+		keys.Draw(text)
 
 		// TODO
 		// selfPlayer := game.Player(0)
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"laby/game"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const discoveryTimeout = 2 * time.Second
+
+type Config struct {
+	Addr          string
+	Port          int
+	Name          string
+	PlayerId      string // created on first start if empty
+	Discover      bool
+	DiscoveryPort int
+	Spectate      bool
+	Timeout       string // give up on a silent server after this long
+	Replay        string // play this replay file instead of joining a server
+	Local         bool   // both players on this computer, no server
+	LogLevel      string // e.g. "info" or "warn,render=debug"
+	Keys          string // key bindings file, F2 changes them in the game
+	Level         string // level file for local play, servers send theirs
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Addr:          "129.27.19.194",
+		Port:          8001,
+		Name:          "",
+		PlayerId:      "",
+		Discover:      false,
+		DiscoveryPort: 8002,
+		Spectate:      false,
+		Timeout:       "10s",
+		Replay:        "",
+		Local:         false,
+		LogLevel:      "info",
+		Keys:          "keys.json",
+		Level:         "levels/gamejam-1.json",
+	}
+}
+
+func (cfg *Config) ServerAddr() string {
+	return net.JoinHostPort(cfg.Addr, strconv.Itoa(cfg.Port))
+}
+
+func (cfg *Config) ServerTimeout() time.Duration {
+	d, _ := time.ParseDuration(cfg.Timeout)
+	return d
+}
+
+func LoadConfig(path string, cfg *Config) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewDecoder(file).Decode(cfg)
+}
+
+// ParseConfig reads the config file and lets command line flags override it.
+func ParseConfig() (*Config, error) {
+	cfg := DefaultConfig()
+	defaults := DefaultConfig()
+
+	configPath := flag.String("config", "client.json", "config file")
+	addr := flag.String("addr", defaults.Addr, "server address")
+	port := flag.Int("port", defaults.Port, "server port")
+	name := flag.String("name", defaults.Name, "player name")
+	discover := flag.Bool("discover", defaults.Discover, "search the LAN for servers and pick one")
+	discoveryPort := flag.Int("discovery-port", defaults.DiscoveryPort, "UDP port for LAN discovery")
+	spectate := flag.Bool("spectate", defaults.Spectate, "watch the session instead of playing")
+	timeout := flag.String("timeout", defaults.Timeout, "give up on a silent server after this long")
+	replay := flag.String("replay", defaults.Replay, "play a recorded session instead of joining a server")
+	local := flag.Bool("local", defaults.Local, "play both roles on one computer in split screen, without a server")
+	logLevel := flag.String("log-level", defaults.LogLevel, "log level, optionally per component, e.g. warn,net=debug")
+	keys := flag.String("keys", defaults.Keys, "key bindings file")
+	level := flag.String("level", defaults.Level, "level file for local play")
+	flag.Parse()
+
+	configSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			configSet = true
+		}
+	})
+
+	if err := LoadConfig(*configPath, cfg); err != nil {
+		if configSet || !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Addr = *addr
+		case "port":
+			cfg.Port = *port
+		case "name":
+			cfg.Name = *name
+		case "discover":
+			cfg.Discover = *discover
+		case "discovery-port":
+			cfg.DiscoveryPort = *discoveryPort
+		case "spectate":
+			cfg.Spectate = *spectate
+		case "timeout":
+			cfg.Timeout = *timeout
+		case "replay":
+			cfg.Replay = *replay
+		case "local":
+			cfg.Local = *local
+		case "log-level":
+			cfg.LogLevel = *logLevel
+		case "keys":
+			cfg.Keys = *keys
+		case "level":
+			cfg.Level = *level
+		}
+	})
+
+	if d, err := time.ParseDuration(cfg.Timeout); err != nil || d <= 0 {
+		return nil, errors.New("Invalid timeout " + cfg.Timeout)
+	}
+
+	nick, err := game.CleanNick(cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Name = nick
+
+	if cfg.PlayerId == "" {
+		id, err := LoadPlayerId()
+		if err != nil {
+			gameLog.Warn("Failed to store player id", "err", err)
+		}
+		cfg.PlayerId = id
+	}
+
+	if !game.ValidPlayerId(cfg.PlayerId) {
+		return nil, errors.New("Invalid player id " + cfg.PlayerId)
+	}
+
+	return cfg, nil
+}
+
+// LoadPlayerId returns the id stored in the user's config directory and
+// creates one on first use.
+func LoadPlayerId() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "laby", "player-id")
+
+	if data, err := ioutil.ReadFile(path); err == nil {
+		id := strings.TrimSpace(string(data))
+		if game.ValidPlayerId(id) {
+			return id, nil
+		}
+	}
+
+	id := game.NewPlayerId()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return id, err
+	}
+	return id, ioutil.WriteFile(path, []byte(id+"\n"), 0644)
+}
+
+// PickServer lists the servers found on the LAN and asks on stdin which one
+// to join.
+func PickServer(cfg *Config) (string, error) {
+	fmt.Println("Searching for laby servers...")
+	servers, err := game.DiscoverServers(cfg.DiscoveryPort, discoveryTimeout)
+	if err != nil {
+		return "", err
+	}
+
+	if len(servers) == 0 {
+		return "", errors.New("No servers found")
+	}
+
+	for i, server := range servers {
+		state := "waiting"
+		if server.Started {
+			state = "running"
+		}
+		fmt.Printf("%d) %s at %s, %d/2 players, %s\n", i+1, server.Name, server.HostPort(), server.Players, state)
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Print("Join server: ")
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+
+		choice, err := strconv.Atoi(strings.TrimSpace(line))
+		if err == nil && choice >= 1 && choice <= len(servers) {
+			return servers[choice-1].HostPort(), nil
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"github.com/banthar/Go-SDL/mixer"
+	"laby/game"
+	"time"
+)
+
+const (
+	shakeTime    = 300 * time.Millisecond
+	shakeWidth   = 4 // pixels to either side
+	tipTime      = 3 * time.Second
+	deniedSound  = "data/trigger2.wav"
+	soundTimeout = 500 * time.Millisecond // holding a key does not rattle on
+)
+
+// Feedback tells the player why an action was refused: the figure shakes when
+// the way is blocked, a sound plays when a lever or boulder does not move and
+// a tip explains the rules that are not obvious.
+type Feedback struct {
+	player game.Player
+	sound  *mixer.Chunk
+
+	shaken   time.Time
+	played   time.Time
+	tip      string
+	tipShown time.Time
+}
+
+func NewFeedback(player game.Player) *Feedback {
+	sound := mixer.LoadWAV(deniedSound)
+	if sound == nil {
+		audioLog.Warn("Failed to load sound", "file", deniedSound)
+	}
+	return &Feedback{player: player, sound: sound}
+}
+
+func (f *Feedback) partner() string {
+	if f.player == game.Human {
+		return game.RoleName(game.Ghost)
+	}
+	return game.RoleName(game.Human)
+}
+
+// Denied reacts to the reason the server or the local game gave. Refusals
+// that happen all the time while a key is held, like acting while still
+// walking, are ignored.
+func (f *Feedback) Denied(reason game.DenyReason) {
+	switch reason {
+	case game.DenyWall, game.DenyBlocked:
+		f.shake()
+	case game.DenyPlayerOnField:
+		f.shake()
+		f.show("Your partner is in the way")
+	case game.DenyBoulderBlocked:
+		f.shake()
+		f.show("Something blocks the boulder")
+	case game.DenyNotYourLever:
+		f.play()
+		f.show("Only the " + f.partner() + " can pull this lever")
+	case game.DenyNotYourBoulder:
+		f.play()
+		f.show("Only the " + f.partner() + " can push this boulder")
+	case game.DenyInTransition:
+		f.play()
+		f.show("Wait until the door has moved")
+	case game.DenyBoulderMoving:
+		f.play()
+		f.show("The boulder is still rolling")
+	case game.DenyNothingToDo:
+		f.play()
+	case game.DenyAlreadyVisible:
+		f.show("Your partner can already see you")
+	case game.DenyVisibilityDelay:
+		f.show("You can show yourself again in a few seconds")
+	case game.DenyTooFast:
+		f.show("Slow down, the server drops actions sent this fast")
+	case game.DenyGameStarted:
+		f.show("The game has already started")
+	case game.DenyUnknownAction:
+		f.play()
+		f.show("The server does not know this action, update your client")
+	case game.DenyBusy:
+	default:
+		f.play()
+		f.show("The server refused that")
+	}
+}
+
+// Blocked reacts to the moves of the player that the game resolved to stay.
+func (f *Feedback) Blocked(events []game.GameEvent) {
+	for _, e := range events {
+		if e.Kind == game.EventBlocked && e.Player == f.player {
+			f.Denied(game.DenyReason(e.Id))
+		}
+	}
+}
+
+func (f *Feedback) shake() {
+	f.shaken = time.Now()
+}
+
+func (f *Feedback) play() {
+	if f.sound == nil || time.Since(f.played) < soundTimeout {
+		return
+	}
+	f.played = time.Now()
+	f.sound.PlayChannel(-1, 0)
+}
+
+func (f *Feedback) show(tip string) {
+	f.tip = tip
+	f.tipShown = time.Now()
+}
+
+// Shake is how far the player's figure is moved aside right now.
+func (f *Feedback) Shake() float32 {
+	age := time.Since(f.shaken)
+	if age > shakeTime {
+		return 0
+	}
+	// three times back and forth, fading out
+	phase := int(age * 12 / shakeTime)
+	width := shakeWidth * float32(shakeTime-age) / float32(shakeTime)
+	if phase%2 == 0 {
+		return width
+	}
+	return -width
+}
+
+func (f *Feedback) Draw(text *TextRenderer) {
+	if f.tip != "" && time.Since(f.tipShown) < tipTime {
+		text.Draw(f.tip, 10, 40)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/banthar/Go-SDL/sdl"
+	"laby/game"
+)
+
+// OpenGamepads opens every joystick SDL finds, they stay open while the
+// client runs. In local play the first one belongs to the left seat and the
+// second one to the right seat.
+func OpenGamepads() []*sdl.Joystick {
+	sdl.JoystickEventState(sdl.ENABLE)
+
+	pads := make([]*sdl.Joystick, 0)
+	for i := 0; i < sdl.NumJoysticks(); i++ {
+		pad := sdl.JoystickOpen(i)
+		if pad == nil {
+			gameLog.Warn("Failed to open gamepad", "index", i, "err", sdl.GetError())
+			continue
+		}
+		gameLog.Info("Gamepad found", "index", i, "name", sdl.JoystickName(i),
+			"axes", pad.NumAxes(), "buttons", pad.NumButtons())
+		pads = append(pads, pad)
+	}
+	return pads
+}
+
+// GamepadOf tells which gamepad sent the event, ok is false for any other
+// event.
+func GamepadOf(event sdl.Event) (which uint8, ok bool) {
+	switch e := event.(type) {
+	case *sdl.JoyAxisEvent:
+		return e.Which, true
+	case *sdl.JoyHatEvent:
+		return e.Which, true
+	case *sdl.JoyButtonEvent:
+		return e.Which, true
+	}
+	return 0, false
+}
+
+// HandleGamepad passes a gamepad event on to the input state.
+func HandleGamepad(is *game.InputState, event sdl.Event) {
+	switch e := event.(type) {
+	case *sdl.JoyAxisEvent:
+		is.HandleJoyAxis(e)
+	case *sdl.JoyHatEvent:
+		is.HandleJoyHat(e)
+	case *sdl.JoyButtonEvent:
+		is.HandleJoyButton(e)
+	}
+}
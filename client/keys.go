@@ -0,0 +1,167 @@
+package main
+
+import (
+	"github.com/banthar/Go-SDL/sdl"
+	"laby/game"
+	"strings"
+)
+
+// KeyScreen lets the player rebind the game keys. F2 opens it, up and down
+// pick a game key, return adds the next key pressed to it, backspace clears
+// it, tab switches between the key maps and escape saves and closes it.
+type KeyScreen struct {
+	bindings *game.Bindings
+	path     string
+	local    bool // the seats of local play are edited, not online play
+
+	open     bool
+	current  int // index into maps
+	selected int // index into game.GameKeys()
+	waiting  bool
+	changed  bool
+	notice   string
+}
+
+func NewKeyScreen(bindings *game.Bindings, path string, local bool) *KeyScreen {
+	return &KeyScreen{bindings: bindings, path: path, local: local}
+}
+
+func (ks *KeyScreen) IsOpen() bool {
+	return ks.open
+}
+
+func (ks *KeyScreen) maps() []string {
+	if ks.local {
+		return []string{"left seat", "right seat"}
+	}
+	return []string{"online play"}
+}
+
+func (ks *KeyScreen) keyMap(i int) game.KeyMap {
+	if !ks.local {
+		return ks.bindings.Online
+	}
+	if i == 0 {
+		return ks.bindings.Left
+	}
+	return ks.bindings.Right
+}
+
+// reserved tells whether the client needs the key for itself.
+func (ks *KeyScreen) reserved(sym uint32) bool {
+	if sym == sdl.K_ESCAPE || (sym >= sdl.K_F1 && sym <= sdl.K_F12) {
+		return true
+	}
+	// the chat
+	return !ks.local && (sym == sdl.K_t || (sym >= sdl.K_1 && sym < sdl.K_1+uint32(len(quickChat))))
+}
+
+// HandleKey returns true if the key was used by the screen and must not reach
+// the game input. Like the chat it never uses key releases.
+func (ks *KeyScreen) HandleKey(e *sdl.KeyboardEvent) bool {
+	if e.Type != sdl.KEYDOWN {
+		return false
+	}
+	sym := e.Keysym.Sym
+
+	if !ks.open {
+		if sym != sdl.K_F2 {
+			return false
+		}
+		ks.open = true
+		ks.notice = ""
+		return true
+	}
+
+	keys := game.GameKeys()
+	if ks.waiting {
+		ks.waiting = false
+		if sym == sdl.K_ESCAPE {
+			return true
+		}
+		if ks.reserved(sym) {
+			ks.notice = game.KeyName(sym) + " cannot be bound"
+			return true
+		}
+		for i, _ := range ks.maps() {
+			if _, ok := ks.keyMap(i)[sym]; ok && i != ks.current {
+				ks.notice = game.KeyName(sym) + " is used by the other seat"
+				return true
+			}
+		}
+		ks.keyMap(ks.current).Bind(sym, keys[ks.selected])
+		ks.changed = true
+		ks.notice = ""
+		return true
+	}
+
+	switch sym {
+	case sdl.K_ESCAPE, sdl.K_F2:
+		ks.open = false
+		if ks.changed {
+			if err := ks.bindings.Save(ks.path); err != nil {
+				gameLog.Error("Failed to save key bindings", "file", ks.path, "err", err)
+			}
+		}
+	case sdl.K_UP:
+		ks.selected = (ks.selected + len(keys) - 1) % len(keys)
+	case sdl.K_DOWN:
+		ks.selected = (ks.selected + 1) % len(keys)
+	case sdl.K_TAB:
+		ks.current = (ks.current + 1) % len(ks.maps())
+	case sdl.K_RETURN, sdl.K_KP_ENTER:
+		ks.waiting = true
+		ks.notice = ""
+	case sdl.K_BACKSPACE, sdl.K_DELETE:
+		ks.keyMap(ks.current).Clear(keys[ks.selected])
+		ks.changed = true
+	}
+	return true
+}
+
+// Changed tells once whether the bindings were changed since the last call,
+// the input states have to pick them up again.
+func (ks *KeyScreen) Changed() bool {
+	if ks.open || !ks.changed {
+		return false
+	}
+	ks.changed = false
+	return true
+}
+
+func (ks *KeyScreen) Draw(text *TextRenderer) {
+	if !ks.open {
+		return
+	}
+
+	y := float32(70)
+	title := "Keys for " + ks.maps()[ks.current]
+	if len(ks.maps()) > 1 {
+		title += " (tab switches)"
+	}
+	text.Draw(title, 10, y)
+	y += 30
+
+	keyMap := ks.keyMap(ks.current)
+	for i, key := range game.GameKeys() {
+		names := make([]string, 0)
+		for _, sym := range keyMap.Keys(key) {
+			names = append(names, game.KeyName(sym))
+		}
+		prefix := "  "
+		if i == ks.selected {
+			prefix = "> "
+		}
+		line := prefix + key.String() + ": " + strings.Join(names, ", ")
+		if i == ks.selected && ks.waiting {
+			line += " + press a key"
+		}
+		text.Draw(line, 10, y)
+		y += 30
+	}
+
+	text.Draw("return adds a key, backspace clears, escape saves and closes", 10, y)
+	if ks.notice != "" {
+		text.Draw(ks.notice, 10, y+30)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"github.com/banthar/Go-SDL/sdl"
+	"laby/game"
+	"strings"
+	"time"
+)
+
+// A seat is one of the two players sharing the computer.
+type seat struct {
+	player   game.Player
+	input    *game.InputState
+	feedback *Feedback
+	keyMap   game.KeyMap
+}
+
+// keyNames lists the seat's keys in the order the key screen shows them.
+func (s *seat) keyNames() string {
+	names := make([]string, 0)
+	for _, key := range game.GameKeys() {
+		for _, sym := range s.keyMap.Keys(key) {
+			names = append(names, game.KeyName(sym))
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// RunLocal plays both roles in one process without a server, the human on
+// the left half of the window with WASD and the ghost on the right half with
+// the arrow keys, unless the bindings say otherwise. F9 restarts the level.
+func RunLocal(renderData *RenderData, text *TextRenderer, bindings *game.Bindings, keysPath string) {
+	localGame, err := game.NewGame()
+	if err != nil {
+		gameLog.Fatal("Failed to initialize game", "err", err)
+	}
+
+	seats := []*seat{
+		&seat{player: localGame.NewPlayer(int(game.Human)), keyMap: bindings.Left},
+		&seat{player: localGame.NewPlayer(int(game.Ghost)), keyMap: bindings.Right},
+	}
+	for _, s := range seats {
+		s.input = game.NewInputStateWithBindings(localGame, s.player, s.keyMap, bindings.Gamepad)
+		s.feedback = NewFeedback(s.player)
+	}
+	keys := NewKeyScreen(bindings, keysPath, true)
+
+	localGame.RecordEvents()
+	start := localGame.Snapshot()
+	last := time.Now()
+
+	running := true
+	for running {
+		Clear()
+		for _, event := range PollEvents() {
+			switch e := event.(type) {
+			case *sdl.QuitEvent:
+				running = false
+			case *sdl.ResizeEvent:
+				sdl.SetVideoMode(int(e.W), int(e.H), 32, sdl.RESIZABLE)
+			case *sdl.JoyAxisEvent, *sdl.JoyHatEvent, *sdl.JoyButtonEvent:
+				if which, _ := GamepadOf(e); int(which) < len(seats) && !keys.IsOpen() {
+					HandleGamepad(seats[which].input, e)
+				}
+			case *sdl.KeyboardEvent:
+				if keys.HandleKey(e) {
+					if keys.Changed() {
+						for _, s := range seats {
+							s.input.SetKeyMap(s.keyMap)
+						}
+					}
+					continue
+				}
+				if e.Type == sdl.KEYDOWN && e.Keysym.Sym == sdl.K_ESCAPE {
+					running = false
+				} else if e.Type == sdl.KEYDOWN && e.Keysym.Sym == sdl.K_F9 {
+					if err := localGame.Rewind(start); err != nil {
+						gameLog.Error("Failed to restart level", "err", err)
+					}
+				}
+
+				for _, s := range seats {
+					s.input.HandleEvent(e)
+				}
+			}
+		}
+
+		current := time.Now()
+		t := current.Sub(last)
+		last = current
+
+		// like the server, actions are performed right away and the tick
+		// is simulated afterwards
+		for _, s := range seats {
+			for _, action := range s.input.StepActions(t) {
+				if err := localGame.PerformPlayerAction(s.player, action); err != nil {
+					gameLog.Debug("Action failed", "player", s.player, "action", action, "err", err)
+					s.feedback.Denied(game.ReasonOf(err))
+				}
+			}
+		}
+		localGame.Update(t)
+		events := localGame.TakeEvents()
+		for _, s := range seats {
+			s.feedback.Blocked(events)
+		}
+
+		for i, s := range seats {
+			SetViewport(i*screenWidth, 0, screenWidth, screenHeight)
+			RenderMap(s.player, renderData, localGame, nil, s.feedback.Shake())
+			text.Draw(game.RoleName(s.player)+": "+s.keyNames(), 10, 10)
+			s.feedback.Draw(text)
+		}
+		SetViewport(0, 0, len(seats)*screenWidth, screenHeight)
+		keys.Draw(text)
+
+		sdl.GL_SwapBuffers()
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"laby/game"
+)
+
+var (
+	gameLog   = game.NewLogger(game.LogGame)
+	netLog    = game.NewLogger(game.LogNet)
+	renderLog = game.NewLogger(game.LogRender)
+	audioLog  = game.NewLogger(game.LogAudio)
+)
+
+// logAsPlayer adds who we play to the game and network lines once the
+// server told us.
+func logAsPlayer(nick string, player game.Player) {
+	gameLog = gameLog.With("player", nick, "role", game.RoleName(player))
+	netLog = netLog.With("player", nick, "role", game.RoleName(player))
+}
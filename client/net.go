@@ -0,0 +1,475 @@
+package main
+
+import (
+	"errors"
+	"laby/game"
+	"net"
+	"time"
+)
+
+const (
+	reconnectTimeout  = 60 * time.Second
+	reconnectInterval = 1 * time.Second
+	dialTimeout       = 2 * time.Second
+	heartbeatInterval = 1 * time.Second
+	rosterInterval    = 1 * time.Second
+)
+
+// Dialer opens a new connection to the server.
+type Dialer func() (game.Transport, error)
+
+// TCPDialer connects to a server on the network.
+func TCPDialer(addr string) Dialer {
+	return func() (game.Transport, error) {
+		conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return game.NewGobTransport(conn), nil
+	}
+}
+
+type ServerConn struct {
+	dialer  Dialer
+	conn    game.Transport
+	join    game.JoinRequest
+	player  game.Player
+	nick    string
+	fog     bool // the server only sends views
+	level   *game.Level
+	timeout time.Duration // server counts as lost after this much silence
+
+	lost      bool
+	lostSince time.Time
+	lastDial  time.Time
+
+	rtt      time.Duration
+	lastPing time.Time
+
+	roster        []game.PlayerInfo
+	lastRosterReq time.Time
+}
+
+func DialServer(addr string, join game.JoinRequest, timeout time.Duration) (*ServerConn, error) {
+	return ConnectServer(TCPDialer(addr), join, timeout)
+}
+
+// ConnectServer joins the server behind dialer, which is also used to
+// reconnect.
+func ConnectServer(dialer Dialer, join game.JoinRequest, timeout time.Duration) (*ServerConn, error) {
+	sc := &ServerConn{
+		dialer:  dialer,
+		join:    join,
+		timeout: timeout,
+	}
+
+	resp, err := sc.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	sc.player = resp.Player
+	sc.nick = resp.Nick
+	sc.fog = resp.Fog
+	sc.level = resp.Level
+	sc.join.Token = resp.Token
+	return sc, nil
+}
+
+func (sc *ServerConn) dial() (game.JoinResponse, error) {
+	var resp game.JoinResponse
+
+	conn, err := sc.dialer()
+	if err != nil {
+		return resp, err
+	}
+
+	conn.SetDeadline(time.Now().Add(sc.timeout))
+	if err := conn.Encode(sc.join); err != nil {
+		conn.Close()
+		return resp, err
+	}
+
+	if err := conn.Decode(&resp); err != nil {
+		conn.Close()
+		return resp, err
+	}
+
+	if !resp.Accepted {
+		conn.Close()
+		return resp, errors.New(resp.Reason)
+	}
+
+	sc.conn = conn
+	return resp, nil
+}
+
+func (sc *ServerConn) Player() game.Player {
+	return sc.player
+}
+
+func (sc *ServerConn) Nick() string {
+	return sc.nick
+}
+
+func (sc *ServerConn) IsSpectator() bool {
+	return sc.join.Spectator
+}
+
+// Fog tells whether the server hides the map, the client then renders views
+// instead of simulating the game.
+func (sc *ServerConn) Fog() bool {
+	return sc.fog
+}
+
+// Level is the level the server plays, only its outline if the map is
+// hidden.
+func (sc *ServerConn) Level() *game.Level {
+	return sc.level
+}
+
+func (sc *ServerConn) Latency() time.Duration {
+	return sc.rtt
+}
+
+// deadline is renewed before every request, a server that does not answer
+// in time makes the request fail.
+func (sc *ServerConn) deadline() {
+	sc.conn.SetDeadline(time.Now().Add(sc.timeout))
+}
+
+// Heartbeat pings the server once per heartbeatInterval and measures the
+// round trip time.
+func (sc *ServerConn) Heartbeat() error {
+	if time.Since(sc.lastPing) < heartbeatInterval {
+		return nil
+	}
+	sc.lastPing = time.Now()
+
+	sc.deadline()
+	if err := sc.conn.Encode(game.ClientReqPing); err != nil {
+		return err
+	}
+	if err := sc.conn.Encode(game.Ping{Sent: sc.lastPing.UnixNano(), LastRTT: sc.rtt}); err != nil {
+		return err
+	}
+
+	var pong game.Pong
+	if err := sc.conn.Decode(&pong); err != nil {
+		return err
+	}
+
+	sc.rtt = time.Since(time.Unix(0, pong.Sent))
+	return nil
+}
+
+func (sc *ServerConn) IsLost() bool {
+	return sc.lost
+}
+
+// ConnectionLost closes the connection, Reconnect tries to get it back.
+func (sc *ServerConn) ConnectionLost(err error) {
+	if sc.lost {
+		return
+	}
+
+	netLog.Warn("Lost connection to server", "err", err)
+	sc.conn.Close()
+	sc.lost = true
+	sc.lostSince = time.Now()
+}
+
+// Reconnect makes at most one dial attempt per reconnectInterval. It returns
+// an error once the server can no longer resume our session.
+func (sc *ServerConn) Reconnect() error {
+	if time.Since(sc.lostSince) > reconnectTimeout {
+		return errors.New("Reconnect timed out")
+	}
+
+	if time.Since(sc.lastDial) < reconnectInterval {
+		return nil
+	}
+	sc.lastDial = time.Now()
+
+	resp, err := sc.dial()
+	if err != nil {
+		netLog.Info("Reconnect failed", "err", err)
+		return nil
+	}
+
+	if !sc.join.Spectator && (!resp.Resumed || resp.Player != sc.player) {
+		sc.conn.Close()
+		return errors.New("Session could not be resumed")
+	}
+
+	netLog.Info("Reconnected")
+	sc.lost = false
+	return nil
+}
+
+func (sc *ServerConn) RequestGameState() (bool, game.Player, bool, error) {
+	var otherPlayer game.Player
+	var otherPlayerJoined bool
+	var gameStartsNow bool
+
+	sc.deadline()
+	if err := sc.conn.Encode(game.ClientReqGameState); err != nil {
+		return false, otherPlayer, false, err
+	}
+
+	if err := sc.conn.Decode(&otherPlayerJoined); err != nil {
+		return false, otherPlayer, false, err
+	}
+
+	if otherPlayerJoined {
+		if err := sc.conn.Decode(&otherPlayer); err != nil {
+			return false, otherPlayer, false, err
+		}
+		if err := sc.conn.Decode(&gameStartsNow); err != nil {
+			return false, otherPlayer, false, err
+		}
+	}
+
+	return otherPlayerJoined, otherPlayer, gameStartsNow, nil
+}
+
+// SendAction returns the tick the server performed the action on, or why it
+// was denied.
+func (sc *ServerConn) SendAction(action game.ActionType) (game.ServerResponse, game.Tick, game.DenyReason, error) {
+	var serverResp game.ServerResponse
+	var tick game.Tick
+	var reason game.DenyReason
+
+	sc.deadline()
+	if err := sc.conn.Encode(game.ClientReqSendAction); err != nil {
+		return serverResp, tick, reason, err
+	}
+	if err := sc.conn.Encode(1); err != nil {
+		return serverResp, tick, reason, err
+	}
+	if err := sc.conn.Encode(action); err != nil {
+		return serverResp, tick, reason, err
+	}
+
+	if err := sc.conn.Decode(&serverResp); err != nil {
+		return serverResp, tick, reason, err
+	}
+
+	var err error
+	switch serverResp {
+	case game.ServerActionOk:
+		err = sc.conn.Decode(&tick)
+	case game.ServerActionDenied:
+		err = sc.conn.Decode(&reason)
+	}
+	return serverResp, tick, reason, err
+}
+
+// ServerUpdate is the server's answer to an update request.
+type ServerUpdate struct {
+	Actions  map[game.Player][]game.TickAction // the partner's new actions
+	Status   game.SessionStatus
+	Tick     game.Tick // the server has simulated up to here
+	HashTick game.Tick // the server wants our state hash for this tick, 0 if not
+}
+
+func (sc *ServerConn) RequestUpdate() (*ServerUpdate, error) {
+	var numPlayers int
+	var numActions int
+	var otherPlayer game.Player
+	var action game.TickAction
+
+	update := &ServerUpdate{
+		Actions: make(map[game.Player][]game.TickAction, 0),
+	}
+
+	sc.deadline()
+	if err := sc.conn.Encode(game.ClientReqUpdate); err != nil {
+		return update, err
+	}
+
+	if err := sc.conn.Decode(&numPlayers); err != nil {
+		return update, err
+	}
+
+	for i := 0; i < numPlayers; i++ {
+		if err := sc.conn.Decode(&otherPlayer); err != nil {
+			return update, err
+		}
+		update.Actions[otherPlayer] = make([]game.TickAction, 0)
+
+		if err := sc.conn.Decode(&numActions); err != nil {
+			return update, err
+		}
+		for j := 0; j < numActions; j++ {
+			if err := sc.conn.Decode(&action); err != nil {
+				return update, err
+			}
+			netLog.Debug("Received action", "from", otherPlayer, "action", action.Action, "tick", action.Tick)
+			update.Actions[otherPlayer] = append(update.Actions[otherPlayer], action)
+		}
+	}
+
+	if err := sc.conn.Decode(&update.Status); err != nil {
+		return update, err
+	}
+	if err := sc.conn.Decode(&update.Tick); err != nil {
+		return update, err
+	}
+
+	err := sc.conn.Decode(&update.HashTick)
+	return update, err
+}
+
+// SendStateHash returns the snapshot to resync from if the server does not
+// agree with our state, nil otherwise.
+func (sc *ServerConn) SendStateHash(stateHash game.StateHash) (*game.GameSnapshot, error) {
+	var resync bool
+
+	sc.deadline()
+	if err := sc.conn.Encode(game.ClientReqHash); err != nil {
+		return nil, err
+	}
+	if err := sc.conn.Encode(stateHash); err != nil {
+		return nil, err
+	}
+
+	if err := sc.conn.Decode(&resync); err != nil {
+		return nil, err
+	}
+	if !resync {
+		return nil, nil
+	}
+
+	var snapshot game.GameSnapshot
+	err := sc.conn.Decode(&snapshot)
+	return &snapshot, err
+}
+
+func (sc *ServerConn) RequestSnapshot() (*game.GameSnapshot, game.SessionStatus, error) {
+	var snapshot game.GameSnapshot
+	var status game.SessionStatus
+
+	sc.deadline()
+	if err := sc.conn.Encode(game.ClientReqSnapshot); err != nil {
+		return nil, status, err
+	}
+
+	if err := sc.conn.Decode(&snapshot); err != nil {
+		return nil, status, err
+	}
+
+	err := sc.conn.Decode(&status)
+	return &snapshot, status, err
+}
+
+func (sc *ServerConn) RequestView() (*game.PlayerView, game.SessionStatus, error) {
+	var view game.PlayerView
+	var status game.SessionStatus
+
+	sc.deadline()
+	if err := sc.conn.Encode(game.ClientReqView); err != nil {
+		return nil, status, err
+	}
+
+	if err := sc.conn.Decode(&status); err != nil {
+		return nil, status, err
+	}
+
+	err := sc.conn.Decode(&view)
+	return &view, status, err
+}
+
+func (sc *ServerConn) Roster() []game.PlayerInfo {
+	return sc.roster
+}
+
+// RefreshRoster asks the server once per rosterInterval who is in the
+// session.
+func (sc *ServerConn) RefreshRoster() error {
+	if time.Since(sc.lastRosterReq) < rosterInterval {
+		return nil
+	}
+	sc.lastRosterReq = time.Now()
+
+	sc.deadline()
+	if err := sc.conn.Encode(game.ClientReqPlayers); err != nil {
+		return err
+	}
+
+	var roster []game.PlayerInfo
+	if err := sc.conn.Decode(&roster); err != nil {
+		return err
+	}
+
+	sc.roster = roster
+	return nil
+}
+
+func (sc *ServerConn) SendMessage(msg game.Message) (game.ServerResponse, error) {
+	var serverResp game.ServerResponse
+
+	sc.deadline()
+	if err := sc.conn.Encode(game.ClientReqMessage); err != nil {
+		return serverResp, err
+	}
+	if err := sc.conn.Encode(msg); err != nil {
+		return serverResp, err
+	}
+
+	err := sc.conn.Decode(&serverResp)
+	return serverResp, err
+}
+
+// RequestMessages fetches the chat lines and map pings that arrived since the
+// last call.
+func (sc *ServerConn) RequestMessages() ([]game.Message, error) {
+	var messages []game.Message
+
+	sc.deadline()
+	if err := sc.conn.Encode(game.ClientReqMessages); err != nil {
+		return nil, err
+	}
+
+	err := sc.conn.Decode(&messages)
+	return messages, err
+}
+
+// RequestSave asks the server to save the session, the reason tells why it
+// did not if it is denied.
+func (sc *ServerConn) RequestSave() (game.ServerResponse, game.DenyReason, error) {
+	var serverResp game.ServerResponse
+	var reason game.DenyReason
+
+	sc.deadline()
+	if err := sc.conn.Encode(game.ClientReqSave); err != nil {
+		return serverResp, reason, err
+	}
+
+	if err := sc.conn.Decode(&serverResp); err != nil {
+		return serverResp, reason, err
+	}
+	if serverResp == game.ServerActionDenied {
+		if err := sc.conn.Decode(&reason); err != nil {
+			return serverResp, reason, err
+		}
+	}
+	return serverResp, reason, nil
+}
+
+// RequestVote asks for an undo or a restart, it happens once the partner
+// asks for the same.
+func (sc *ServerConn) RequestVote(kind game.VoteKind) (game.ServerResponse, error) {
+	var serverResp game.ServerResponse
+
+	sc.deadline()
+	if err := sc.conn.Encode(game.ClientReqVote); err != nil {
+		return serverResp, err
+	}
+	if err := sc.conn.Encode(kind); err != nil {
+		return serverResp, err
+	}
+
+	err := sc.conn.Decode(&serverResp)
+	return serverResp, err
+}
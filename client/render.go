@@ -3,8 +3,8 @@ package main
 import (
 	"fmt"
 	"laby/game"
-	"log"
 	"math"
+	"time"
 )
 
 type GhostSprites struct {
@@ -34,51 +34,58 @@ type FloorSprites struct {
 type ToolSprites struct {
 	triggersA *Sprite
 	triggersB *Sprite
+	marker    *Sprite
 }
 
 func LoadToolSprites() *ToolSprites {
 	triggerSpriteA, err := NewSprite("data/lever0_on.png", 128, 128)
 	if err != nil {
-		log.Fatal("Could not open trigger file")
+		renderLog.Fatal("Could not open trigger file", "err", err)
 	}
 
 	triggerSpriteB, err := NewSprite("data/lever0_off.png", 128, 128)
 	if err != nil {
-		log.Fatal("Could not open trigger file")
+		renderLog.Fatal("Could not open trigger file", "err", err)
+	}
+
+	markerSprite, err := NewSprite("data/coin.png", 203, 209)
+	if err != nil {
+		renderLog.Fatal("Could not open marker file", "err", err)
 	}
 
 	return &ToolSprites{
 		triggersA: triggerSpriteA,
 		triggersB: triggerSpriteB,
+		marker:    markerSprite,
 	}
 }
 
 func LoadFloorSprites() *FloorSprites {
 	floorSprite, err := NewSprite("data/floor/floor.png", 64, 64)
 	if err != nil {
-		log.Fatal("Could not open floor tile")
+		renderLog.Fatal("Could not open floor tile", "err", err)
 	}
 
 	floorSprite2, err := NewSprite("data/000 GAME JAM/boden.png", 64, 64)
 	if err != nil {
-		log.Fatal("Could not open steinboden")
+		renderLog.Fatal("Could not open steinboden", "err", err)
 	}
 
 	boulderSprite, err := NewSprite("data/floor/boulder.png", 64, 64)
 	if err != nil {
-		log.Fatal("Could not open boulder tile")
+		renderLog.Fatal("Could not open boulder tile", "err", err)
 	}
 
 	doorSprite, err := NewSprite("data/000 GAME JAM/door.png", 64, 64)
 	if err != nil {
-		log.Fatal("Could not open boulder tile")
+		renderLog.Fatal("Could not open door tile", "err", err)
 	}
 
 	banSprites := make([]*Sprite, 4)
 	for i := 1; i < 5; i++ {
 		banSprites[i-1], err = NewSprite(fmt.Sprintf("data/ban/%d.png", i), 64, 64)
 		if err != nil {
-			log.Fatal("Could not load ban")
+			renderLog.Fatal("Could not load ban", "err", err)
 		}
 	}
 
@@ -256,7 +263,9 @@ func FloatPosToWorldCoord(pos game.Position) (float32, float32) {
 
 var tileSize float32 = 0.8 * 64
 
-func RenderMap(player game.Player, renderData *RenderData, g *game.Game) {
+// RenderMap draws what player sees, shake moves the player's own figure
+// aside.
+func RenderMap(player game.Player, renderData *RenderData, g *game.Game, markers []Marker, shake float32) {
 	wall := renderData.wallSprites.walls[0]
 	floor := renderData.floorSprites.floor
 	floor2 := renderData.floorSprites.floor2
@@ -269,6 +278,7 @@ func RenderMap(player game.Player, renderData *RenderData, g *game.Game) {
 	doorSprite := renderData.floorSprites.door
 	triggerA := renderData.toolSprites.triggersA
 	triggerB := renderData.toolSprites.triggersB
+	markerSprite := renderData.toolSprites.marker
 
 	plateSprite := renderData.floorSprites.plate0
 
@@ -368,6 +378,9 @@ func RenderMap(player game.Player, renderData *RenderData, g *game.Game) {
 		}
 		renderPos := g.PlayerRenderPos(otherPlayer)
 		wx, wy := FloatPosToWorldCoord(renderPos)
+		if otherPlayer == player {
+			wx += shake
+		}
 		if g.IsHuman(otherPlayer) {
 			direction := g.PlayerDirection(otherPlayer)
 			if g.PlayerIsWalking(otherPlayer) {
@@ -389,7 +402,16 @@ func RenderMap(player game.Player, renderData *RenderData, g *game.Game) {
 				ghostStand[direction][0].Draw(wx+offset, wy+offset, 0, scaleMod*64/256.0, true)
 			}
 		} else {
-			log.Fatal("To many players")
+			renderLog.Fatal("Too many players")
 		}
 	}
+
+	// pings pulse so they catch the eye, they are shown even on cells the
+	// player cannot see
+	for _, marker := range markers {
+		wx, wy := ToWorldCoord(marker.pos)
+		age := float64(time.Since(marker.created)) / float64(time.Second)
+		pulse := float32(0.8 + 0.2*math.Sin(age*2*math.Pi*2))
+		markerSprite.Draw(wx+offset, wy+offset, 0, scaleMod*pulse*48/209.0, true)
+	}
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"github.com/banthar/Go-SDL/sdl"
+	"laby/game"
+	"time"
+)
+
+const (
+	replaySeekStep = 5 * time.Second
+	minReplaySpeed = 0.25
+	maxReplaySpeed = 16
+)
+
+func replayTime(ticks game.Tick) string {
+	d := time.Duration(ticks) * game.TickDuration
+	return fmt.Sprintf("%02d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// RunReplay plays a recorded session until the window is closed. Space
+// pauses, up and down change the speed, left and right seek, 0 starts over
+// and tab switches between the full map and the players' views.
+func RunReplay(replay *game.Replay, renderData *RenderData, text *TextRenderer) {
+	rp, err := game.NewReplayPlayer(replay)
+	if err != nil {
+		gameLog.Fatal("Failed to start replay", "err", err)
+	}
+
+	roster := make([]game.PlayerInfo, 0, len(replay.Header.Players))
+	for _, player := range replay.Header.Players {
+		roster = append(roster, game.PlayerInfo{Player: player.Player, Nick: player.Nick, Connected: true})
+	}
+
+	seekStep := game.Tick(replaySeekStep / game.TickDuration)
+	currentView := 0
+	last := time.Now()
+
+	running := true
+	for running {
+		Clear()
+		for _, event := range PollEvents() {
+			switch e := event.(type) {
+			case *sdl.QuitEvent:
+				running = false
+			case *sdl.ResizeEvent:
+				sdl.SetVideoMode(int(e.W), int(e.H), 32, sdl.RESIZABLE)
+			case *sdl.KeyboardEvent:
+				if e.Type != sdl.KEYDOWN {
+					continue
+				}
+
+				var err error
+				switch e.Keysym.Sym {
+				case sdl.K_ESCAPE:
+					running = false
+				case sdl.K_TAB:
+					currentView = (currentView + 1) % len(spectatorViews)
+				case sdl.K_SPACE:
+					if rp.IsPaused() && rp.Tick() >= rp.EndTick() {
+						err = rp.Seek(rp.StartTick())
+					}
+					rp.SetPaused(!rp.IsPaused())
+				case sdl.K_UP:
+					if rp.Speed() < maxReplaySpeed {
+						rp.SetSpeed(rp.Speed() * 2)
+					}
+				case sdl.K_DOWN:
+					if rp.Speed() > minReplaySpeed {
+						rp.SetSpeed(rp.Speed() / 2)
+					}
+				case sdl.K_RIGHT:
+					err = rp.Seek(rp.Tick() + seekStep)
+				case sdl.K_LEFT:
+					if rp.Tick() > rp.StartTick()+seekStep {
+						err = rp.Seek(rp.Tick() - seekStep)
+					} else {
+						err = rp.Seek(rp.StartTick())
+					}
+				case sdl.K_0:
+					err = rp.Seek(rp.StartTick())
+				}
+				if err != nil {
+					gameLog.Warn("Failed to seek replay", "err", err)
+				}
+			}
+		}
+
+		current := time.Now()
+		rp.Update(current.Sub(last))
+		last = current
+
+		view := spectatorViews[currentView]
+		if !hasPlayer(rp.Game(), view) {
+			view = game.Observer
+		}
+
+		RenderMap(view, renderData, rp.Game(), nil, 0)
+
+		state := fmt.Sprintf("x%g", rp.Speed())
+		if rp.IsPaused() {
+			state = "paused"
+		}
+		text.Draw(fmt.Sprintf("Replay %s / %s %s, %s (tab to switch)",
+			replayTime(rp.Tick()-rp.StartTick()), replayTime(rp.EndTick()-rp.StartTick()),
+			state, spectatorViewName(view)), 10, 10)
+		DrawRoster(text, roster, game.Observer)
+
+		sdl.GL_SwapBuffers()
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"github.com/banthar/Go-SDL/sdl"
+	"laby/game"
+)
+
+// Views a spectator can switch between with the tab key.
+var spectatorViews = []game.Player{game.Observer, game.Human, game.Ghost}
+
+func spectatorViewName(view game.Player) string {
+	switch view {
+	case game.Human:
+		return "human view"
+	case game.Ghost:
+		return "ghost view"
+	}
+	return "full map"
+}
+
+func hasPlayer(g *game.Game, player game.Player) bool {
+	for _, p := range g.Players() {
+		if p == player {
+			return true
+		}
+	}
+	return false
+}
+
+// RunSpectator shows the session from the full map or from one player's
+// point of view until the window is closed.
+func RunSpectator(sc *ServerConn, renderData *RenderData, text *TextRenderer) {
+	spectatorGame, _ := game.NewGame()
+	currentView := 0
+	status := game.SessionRunning
+	chat := NewChatState()
+
+	running := true
+	for running {
+		Clear()
+		for _, event := range PollEvents() {
+			switch e := event.(type) {
+			case *sdl.QuitEvent:
+				running = false
+			case *sdl.ResizeEvent:
+				sdl.SetVideoMode(int(e.W), int(e.H), 32, sdl.RESIZABLE)
+			case *sdl.KeyboardEvent:
+				if e.Type == sdl.KEYDOWN {
+					switch e.Keysym.Sym {
+					case sdl.K_ESCAPE:
+						running = false
+					case sdl.K_TAB:
+						currentView = (currentView + 1) % len(spectatorViews)
+					}
+				}
+			}
+		}
+
+		if sc.IsLost() {
+			if err := sc.Reconnect(); err != nil {
+				netLog.Fatal("Lost connection to server", "err", err)
+			}
+		}
+
+		if !sc.IsLost() {
+			if err := sc.Heartbeat(); err != nil {
+				sc.ConnectionLost(err)
+			}
+		}
+
+		if !sc.IsLost() {
+			if err := sc.RefreshRoster(); err != nil {
+				sc.ConnectionLost(err)
+			}
+		}
+
+		if !sc.IsLost() {
+			if err := chat.Exchange(sc); err != nil {
+				sc.ConnectionLost(err)
+			}
+		}
+
+		if !sc.IsLost() {
+			snapshot, newStatus, err := sc.RequestSnapshot()
+			if err != nil {
+				sc.ConnectionLost(err)
+			} else if err := spectatorGame.RestoreSnapshot(snapshot); err != nil {
+				gameLog.Error("Failed to restore snapshot", "err", err)
+			} else {
+				status = newStatus
+			}
+		}
+
+		view := spectatorViews[currentView]
+		if !hasPlayer(spectatorGame, view) {
+			view = game.Observer
+		}
+
+		RenderMap(view, renderData, spectatorGame, chat.Markers(), 0)
+
+		text.Draw("Spectating: "+spectatorViewName(view)+" (tab to switch)", 10, 10)
+		if sc.IsLost() {
+			text.Draw("Connection lost, reconnecting...", 10, 40)
+		} else if len(spectatorGame.Players()) < 2 {
+			text.Draw("Waiting for players...", 10, 40)
+		} else if status == game.SessionPartnerDisconnected {
+			text.Draw("A player left, waiting for them to come back...", 10, 40)
+		}
+		DrawLatency(text, sc.Latency())
+		DrawRoster(text, sc.Roster(), game.Observer)
+		chat.Draw(text)
+
+		sdl.GL_SwapBuffers()
+	}
+}
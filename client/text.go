@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"github.com/banthar/Go-SDL/sdl"
+	"github.com/banthar/Go-SDL/ttf"
+	"laby/game"
+	"strings"
+	"time"
+)
+
+const maxCachedTexts = 256
+
+type TextRenderer struct {
+	font  *ttf.Font
+	color sdl.Color
+	cache map[string]*Sprite
+}
+
+func NewTextRenderer(path string, size int) *TextRenderer {
+	font := ttf.OpenFont(path, size)
+	if font == nil {
+		renderLog.Error("Failed to open font", "file", path, "err", sdl.GetError())
+	}
+
+	return &TextRenderer{
+		font:  font,
+		color: sdl.Color{R: 0, G: 0, B: 0},
+		cache: make(map[string]*Sprite),
+	}
+}
+
+func (tr *TextRenderer) sprite(text string) *Sprite {
+	if sprite, ok := tr.cache[text]; ok {
+		return sprite
+	}
+
+	if len(tr.cache) >= maxCachedTexts {
+		for key, sprite := range tr.cache {
+			sprite.tex.Delete()
+			delete(tr.cache, key)
+		}
+	}
+
+	surface := ttf.RenderUTF8_Blended(tr.font, text, tr.color)
+	if surface == nil {
+		return nil
+	}
+	defer surface.Free()
+
+	sprite := NewSpriteFromSurface(surface)
+	tr.cache[text] = sprite
+	return sprite
+}
+
+// Draw renders text with its top left corner at x, y.
+func (tr *TextRenderer) Draw(text string, x, y float32) {
+	if tr.font == nil || text == "" {
+		return
+	}
+
+	if sprite := tr.sprite(text); sprite != nil {
+		sprite.Draw(x+sprite.width/2, y+sprite.height/2, 0, 1, true)
+	}
+}
+
+func DrawLatency(text *TextRenderer, rtt time.Duration) {
+	text.Draw(fmt.Sprintf("%d ms", rtt/time.Millisecond), screenWidth-80, 10)
+}
+
+// DrawRoster shows who plays which role, self is marked.
+func DrawRoster(text *TextRenderer, roster []game.PlayerInfo, self game.Player) {
+	names := make([]string, 0, len(roster))
+	for _, info := range roster {
+		name := fmt.Sprintf("%s (%s)", info.Nick, game.RoleName(info.Player))
+		if info.Player == self {
+			name = "you: " + name
+		}
+		if !info.Connected {
+			name += " - disconnected"
+		}
+		names = append(names, name)
+	}
+
+	text.Draw(strings.Join(names, "   "), 10, screenHeight-34)
+}
@@ -10,7 +10,6 @@ import (
 	"image/color"
 	"image/draw"
 	_ "image/png"
-	"log"
 	"math"
 	"os"
 	"unsafe"
@@ -104,7 +103,7 @@ func uploadTexture(img *image.RGBA) gl.Texture {
 func NewSprite(path string, width, height float32) (*Sprite, error) {
 	img, err := LoadImageRGBA(path)
 	if err != nil {
-		log.Println(err)
+		renderLog.Warn("Failed to load sprite", "file", path, "err", err)
 		return nil, err
 	}
 	tex := uploadTexture(img)
@@ -166,6 +165,15 @@ func Clear() {
 	gl.Clear(gl.COLOR_BUFFER_BIT)
 }
 
+// SetViewport draws into the w x h rectangle whose lower left corner is at
+// x, y in the window, with coordinates starting at its upper left corner.
+func SetViewport(x, y, w, h int) {
+	gl.Viewport(x, y, w, h)
+	gl.MatrixMode(gl.PROJECTION)
+	gl.LoadIdentity()
+	gl.Ortho(0, float64(w), float64(h), 0, -1.0, 1.0)
+}
+
 func LoadImageRGBA(path string) (*image.RGBA, error) {
 	file, err := os.Open(path)
 	if err != nil {
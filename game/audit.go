@@ -0,0 +1,70 @@
+package game
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+// Kinds of audit events besides the GameEvent kinds.
+const (
+	AuditConnect      = "connect"    // a player or spectator joined, Addr is set
+	AuditResume       = "resume"     // a player came back on a new connection
+	AuditDisconnect   = "disconnect" // the connection of a player was lost
+	AuditLeave        = "leave"      // a player left the session for good
+	AuditRole         = "role"       // one per player when the session starts
+	AuditSessionStart = "session-start"
+	AuditSessionEnd   = "session-end"
+	AuditAction       = "action" // Result is accepted or denied, Reason names the DenyReason
+	AuditUndo         = "undo"
+	AuditRestart      = "restart"
+)
+
+const (
+	AuditAccepted = "accepted"
+	AuditDenied   = "denied"
+)
+
+// An AuditEvent is one line of the server's audit log.
+type AuditEvent struct {
+	Time    time.Time
+	Session string `json:",omitempty"`
+	Tick    Tick   `json:",omitempty"`
+	Kind    string
+	Player  *Player `json:",omitempty"`
+	Nick    string  `json:",omitempty"`
+	Addr    string  `json:",omitempty"`
+	Action  string  `json:",omitempty"`
+	Result  string  `json:",omitempty"`
+	Reason  string  `json:",omitempty"`
+	Id      int     `json:",omitempty"` // of the trigger, door, plate or room, the goal room when the level is completed
+	Active  bool    `json:",omitempty"`
+}
+
+// ReadAuditLog reads the events of an audit log. Lines that were cut off by
+// a crash are skipped.
+func ReadAuditLog(r io.Reader) ([]AuditEvent, error) {
+	events := make([]AuditEvent, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return events, scanner.Err()
+}
+
+func ReadAuditLogFile(path string) ([]AuditEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadAuditLog(file)
+}
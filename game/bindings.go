@@ -0,0 +1,248 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/banthar/Go-SDL/sdl"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The key bindings file is JSON with a key map for online play and one for
+// either seat of local play, e.g.
+//
+//	{"Online": {"up": ["z", "up"], "left": ["q"]}, "Left": {"action": ["left-ctrl"]}}
+//
+// Game keys left out keep their default keys. Keys are named like "a", "7",
+// "space" or "left-shift", any other key by its SDL number, e.g. "key246".
+
+var gameKeyNames = []string{"left", "up", "right", "down", "action", "visibility"}
+
+func (k Key) String() string {
+	if k < 0 || int(k) >= len(gameKeyNames) {
+		return "key" + strconv.Itoa(int(k))
+	}
+	return gameKeyNames[k]
+}
+
+// GameKeys lists the game keys in the order they are shown.
+func GameKeys() []Key {
+	return []Key{KeyW, KeyA, KeyS, KeyD, KeySpace, KeyEnter}
+}
+
+func ParseGameKey(name string) (Key, error) {
+	for i, keyName := range gameKeyNames {
+		if keyName == name {
+			return Key(i), nil
+		}
+	}
+	return 0, errors.New("Unknown game key " + name)
+}
+
+var keyNames = map[uint32]string{
+	sdl.K_BACKSPACE:    "backspace",
+	sdl.K_TAB:          "tab",
+	sdl.K_RETURN:       "return",
+	sdl.K_SPACE:        "space",
+	sdl.K_QUOTE:        "'",
+	sdl.K_COMMA:        ",",
+	sdl.K_MINUS:        "-",
+	sdl.K_PERIOD:       ".",
+	sdl.K_SLASH:        "/",
+	sdl.K_SEMICOLON:    ";",
+	sdl.K_EQUALS:       "=",
+	sdl.K_LEFTBRACKET:  "[",
+	sdl.K_BACKSLASH:    "\\",
+	sdl.K_RIGHTBRACKET: "]",
+	sdl.K_BACKQUOTE:    "`",
+	sdl.K_DELETE:       "delete",
+	sdl.K_KP_ENTER:     "keypad-enter",
+	sdl.K_UP:           "up",
+	sdl.K_DOWN:         "down",
+	sdl.K_RIGHT:        "right",
+	sdl.K_LEFT:         "left",
+	sdl.K_INSERT:       "insert",
+	sdl.K_HOME:         "home",
+	sdl.K_END:          "end",
+	sdl.K_PAGEUP:       "page-up",
+	sdl.K_PAGEDOWN:     "page-down",
+	sdl.K_RSHIFT:       "right-shift",
+	sdl.K_LSHIFT:       "left-shift",
+	sdl.K_RCTRL:        "right-ctrl",
+	sdl.K_LCTRL:        "left-ctrl",
+	sdl.K_RALT:         "right-alt",
+	sdl.K_LALT:         "left-alt",
+}
+
+func init() {
+	for c := 'a'; c <= 'z'; c++ {
+		keyNames[uint32(c)] = string(c)
+	}
+	for c := '0'; c <= '9'; c++ {
+		keyNames[uint32(c)] = string(c)
+	}
+	for i := uint32(0); i <= 9; i++ {
+		keyNames[sdl.K_KP0+i] = "keypad-" + strconv.Itoa(int(i))
+	}
+}
+
+// KeyName names a keyboard key for the bindings file.
+func KeyName(sym uint32) string {
+	if name, ok := keyNames[sym]; ok {
+		return name
+	}
+	return "key" + strconv.Itoa(int(sym))
+}
+
+func ParseKeyName(name string) (uint32, error) {
+	for sym, keyName := range keyNames {
+		if keyName == name {
+			return sym, nil
+		}
+	}
+	if strings.HasPrefix(name, "key") {
+		if sym, err := strconv.ParseUint(name[3:], 10, 32); err == nil && sym > 0 && sym < sdl.K_LAST {
+			return uint32(sym), nil
+		}
+	}
+	return 0, errors.New("Unknown key " + name)
+}
+
+// Keys returns the keyboard keys bound to the game key.
+func (km KeyMap) Keys(key Key) []uint32 {
+	syms := make([]uint32, 0, 2)
+	for sym, k := range km {
+		if k == key {
+			syms = append(syms, sym)
+		}
+	}
+	sort.Slice(syms, func(i, j int) bool { return syms[i] < syms[j] })
+	return syms
+}
+
+// Bind adds the keyboard key to the game key, it is taken from the game key
+// it was bound to before.
+func (km KeyMap) Bind(sym uint32, key Key) {
+	km[sym] = key
+}
+
+// Clear unbinds every keyboard key of the game key.
+func (km KeyMap) Clear(key Key) {
+	for sym, k := range km {
+		if k == key {
+			delete(km, sym)
+		}
+	}
+}
+
+func (km KeyMap) Copy() KeyMap {
+	c := make(KeyMap, len(km))
+	for sym, key := range km {
+		c[sym] = key
+	}
+	return c
+}
+
+func (km KeyMap) MarshalJSON() ([]byte, error) {
+	names := make(map[string][]string)
+	for _, key := range GameKeys() {
+		keys := make([]string, 0)
+		for _, sym := range km.Keys(key) {
+			keys = append(keys, KeyName(sym))
+		}
+		names[key.String()] = keys
+	}
+	return json.Marshal(names)
+}
+
+// UnmarshalJSON replaces the keys of the game keys in data, the others keep
+// theirs.
+func (km *KeyMap) UnmarshalJSON(data []byte) error {
+	var names map[string][]string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+
+	for gameKey, _ := range names {
+		if _, err := ParseGameKey(gameKey); err != nil {
+			return err
+		}
+	}
+
+	if *km == nil {
+		*km = make(KeyMap)
+	}
+	// a key listed twice goes to the game key shown last
+	for _, key := range GameKeys() {
+		keys, ok := names[key.String()]
+		if !ok {
+			continue
+		}
+		km.Clear(key)
+		for _, name := range keys {
+			sym, err := ParseKeyName(name)
+			if err != nil {
+				return err
+			}
+			km.Bind(sym, key)
+		}
+	}
+	return nil
+}
+
+// Bindings are the key maps of online and local play and the gamepad
+// buttons, which are the same for every gamepad.
+type Bindings struct {
+	Online  KeyMap
+	Left    KeyMap
+	Right   KeyMap
+	Gamepad PadMap
+}
+
+func DefaultBindings() *Bindings {
+	return &Bindings{
+		Online:  DefaultKeyMap(),
+		Left:    LeftKeyMap(),
+		Right:   RightKeyMap(),
+		Gamepad: DefaultPadMap(),
+	}
+}
+
+// LoadBindings reads the bindings file, the defaults are used if there is
+// none.
+func LoadBindings(path string) (*Bindings, error) {
+	b := DefaultBindings()
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return b, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(b); err != nil {
+		return nil, err
+	}
+
+	for sym, _ := range b.Left {
+		if _, ok := b.Right[sym]; ok {
+			return nil, errors.New("Key " + KeyName(sym) + " is bound for both seats")
+		}
+	}
+	if err := b.Gamepad.Valid(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *Bindings) Save(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
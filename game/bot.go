@@ -0,0 +1,476 @@
+package game
+
+import (
+	"sort"
+	"strings"
+)
+
+// Bot plays one role for a player without a partner. It only plans with what
+// its role can see: it walks to triggers that open a closed door or drop a
+// boulder, points out the ones only its partner can use and follows the
+// partner's pings. A ping on a plate makes the human push a boulder there.
+type Bot struct {
+	player  Player
+	target  *MapPosition // pinged by the partner
+	follow  bool         // walk next to the partner instead
+	hinted  map[MapPosition]bool
+	outbox  []Message
+	waiting bool // the way is blocked by the partner
+}
+
+func NewBot(player Player) *Bot {
+	return &Bot{
+		player: player,
+		target: nil,
+		hinted: make(map[MapPosition]bool),
+		outbox: make([]Message, 0),
+	}
+}
+
+func (b *Bot) Player() Player {
+	return b.player
+}
+
+func (b *Bot) say(text string) {
+	b.outbox = append(b.outbox, Message{Kind: MessageChat, Text: text})
+}
+
+func (b *Bot) ping(pos MapPosition) {
+	b.outbox = append(b.outbox, Message{Kind: MessageMarker, X: pos.x, Y: pos.y})
+}
+
+// TakeMessages returns what the bot wants to tell its partner since the last
+// call.
+func (b *Bot) TakeMessages() []Message {
+	messages := b.outbox
+	b.outbox = make([]Message, 0)
+	return messages
+}
+
+// Hear takes commands from the partner: a ping sends the bot there, "stop"
+// makes it wait and "come" makes it walk over to the partner.
+func (b *Bot) Hear(g *Game, msg Message) {
+	if msg.From == b.player {
+		return
+	}
+
+	switch msg.Kind {
+	case MessageMarker:
+		pos := NewMapPosition(msg.X, msg.Y)
+		b.target = &pos
+		b.follow = false
+		b.say("On my way")
+	case MessageChat:
+		switch strings.ToLower(strings.TrimSpace(msg.Text)) {
+		case "stop", "wait":
+			b.target = nil
+			b.follow = false
+			b.say("Waiting")
+		case "come", "follow":
+			b.target = nil
+			b.follow = true
+			b.say("Coming")
+		}
+	}
+}
+
+// NextAction decides what to do once the bot's player stands still, false
+// means there is nothing to do right now.
+func (b *Bot) NextAction(g *Game) (ActionType, bool) {
+	if g.PlayerIsWalking(b.player) || g.PlayerDoesAction(b.player) || g.HasIntent(b.player) {
+		return ActionNoAction, false
+	}
+
+	if b.target != nil || b.follow {
+		if action, ok := b.followTarget(g); ok {
+			return action, true
+		}
+		if b.target != nil || b.follow {
+			return ActionNoAction, false
+		}
+	}
+
+	b.hint(g)
+
+	pos := g.playerState[b.player].mapPos
+	if trigger, ok := g.triggers[pos]; ok && b.useful(g, trigger) && g.PlayerCanTrigger(b.player, trigger) {
+		return ActionAction, true
+	}
+
+	goal := func(p MapPosition) bool {
+		trigger, ok := g.triggers[p]
+		return ok && b.useful(g, trigger) && g.PlayerCanTrigger(b.player, trigger) &&
+			g.PlayerCanSeeTrigger(b.player, trigger)
+	}
+	if dirs, ok := b.path(g, goal, false); ok && len(dirs) > 0 {
+		return moveAction(dirs[0]), true
+	}
+
+	return ActionNoAction, false
+}
+
+func (b *Bot) followTarget(g *Game) (ActionType, bool) {
+	pos := g.playerState[b.player].mapPos
+
+	var target MapPosition
+	if b.follow {
+		for player, state := range g.playerState {
+			if player != b.player {
+				target = state.mapPos
+			}
+		}
+	} else {
+		target = *b.target
+	}
+
+	if _, ok := g.plates[target]; ok && !b.follow && g.IsHuman(b.player) {
+		if g.IsBoulder(target) {
+			b.target = nil
+			b.say("Done")
+			return ActionNoAction, false
+		}
+		if action, ok := b.pushToPlate(g, target); ok {
+			return action, true
+		}
+	}
+
+	if pos == target {
+		b.target = nil
+		if trigger, ok := g.triggers[pos]; ok && g.PlayerCanTrigger(b.player, trigger) {
+			b.say("Done")
+			return ActionAction, true
+		}
+		b.say("I'm here")
+		return ActionNoAction, false
+	}
+
+	// cells the bot cannot stand on are reached from next to them
+	standable := !b.follow && b.passable(g, target, true)
+	goal := func(p MapPosition) bool {
+		if standable {
+			return p == target
+		}
+		for _, dir := range Dirs() {
+			if p.Neighbor(dir) == target {
+				return true
+			}
+		}
+		return false
+	}
+
+	if goal(pos) {
+		if b.follow {
+			b.say("Here I am")
+		} else {
+			b.say("As close as I can get")
+		}
+		b.target = nil
+		b.follow = false
+		return ActionNoAction, false
+	}
+
+	if dirs, ok := b.path(g, goal, false); ok {
+		b.waiting = false
+		return moveAction(dirs[0]), true
+	}
+
+	if _, ok := b.path(g, goal, true); ok {
+		if !b.waiting {
+			b.say("You are in my way")
+			b.waiting = true
+		}
+		return ActionNoAction, false
+	}
+
+	b.target = nil
+	b.follow = false
+	b.say("I can't get there")
+	return ActionNoAction, false
+}
+
+// hint pings useful triggers the partner has to use and, for a human
+// partner, plates that need a boulder. Each one is pointed out once.
+func (b *Bot) hint(g *Game) {
+	for _, partner := range g.players {
+		if partner == b.player {
+			continue
+		}
+
+		for pos, trigger := range g.triggers {
+			if b.hinted[pos] || !b.useful(g, trigger) || !g.PlayerCanSeeTrigger(b.player, trigger) {
+				continue
+			}
+			if g.PlayerCanTrigger(partner, trigger) && !g.PlayerCanTrigger(b.player, trigger) {
+				b.hinted[pos] = true
+				b.ping(pos)
+				b.say("You can use that one")
+			}
+		}
+
+		if !g.IsHuman(partner) {
+			continue
+		}
+		for pos, plate := range g.plates {
+			if b.hinted[pos] || plate.linkedDoor == nil || plate.linkedDoor.isOpen || !g.PlayerCanSeePlate(b.player, plate) {
+				continue
+			}
+			if b.pushableBoulder(g, partner) {
+				b.hinted[pos] = true
+				b.ping(pos)
+				b.say("Push a boulder here")
+			}
+		}
+	}
+}
+
+// useful tells if using the trigger opens a closed door or drops a boulder.
+func (b *Bot) useful(g *Game, trigger *Trigger) bool {
+	if trigger.isActive {
+		return false
+	}
+	if _, ok := g.triggerTransition[trigger]; ok {
+		return false
+	}
+	if trigger.linkedDoor != nil && !trigger.linkedDoor.isOpen {
+		return true
+	}
+	return trigger.spawnBoulder != nil && !trigger.spawnBoulder.active
+}
+
+func (b *Bot) pushableBoulder(g *Game, player Player) bool {
+	for _, boulder := range g.boulders {
+		if boulder.active && g.PlayerCanPush(player, boulder) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Bot) partnerAt(g *Game, pos MapPosition) bool {
+	for player, state := range g.playerState {
+		if player == b.player {
+			continue
+		}
+		if state.mapPos == pos {
+			return true
+		}
+		if transition, ok := g.playerMoveTransition[player]; ok && transition.TargetPos() == pos {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Bot) inside(g *Game, pos MapPosition) bool {
+	return pos.x >= 0 && pos.y >= 0 && pos.x < g.Width() && pos.y < g.Height()
+}
+
+// passable mirrors PlayerMove for cells the bot knows about.
+func (b *Bot) passable(g *Game, pos MapPosition, ignorePartner bool) bool {
+	if !b.inside(g, pos) || !g.PlayerCanSeeCell(b.player, pos) || g.IsWall(pos) {
+		return false
+	}
+	if door, ok := g.doors[pos]; ok && !door.isOpen && !g.PlayerCanPassDoor(b.player, door) {
+		return false
+	}
+	if boulder, ok := g.boulders[pos]; ok && !g.PlayerCanPassBoulder(b.player, boulder) {
+		return false
+	}
+	return ignorePartner || !b.partnerAt(g, pos)
+}
+
+// path finds the shortest walk to a cell goal accepts.
+func (b *Bot) path(g *Game, goal func(MapPosition) bool, ignorePartner bool) ([]Direction, bool) {
+	start := g.playerState[b.player].mapPos
+	if goal(start) {
+		return nil, true
+	}
+
+	from := map[MapPosition]Direction{}
+	visited := map[MapPosition]bool{start: true}
+	queue := []MapPosition{start}
+
+	for len(queue) > 0 {
+		pos := queue[0]
+		queue = queue[1:]
+
+		for _, dir := range Dirs() {
+			next := pos.Neighbor(dir)
+			if visited[next] || !b.passable(g, next, ignorePartner) {
+				continue
+			}
+			visited[next] = true
+			from[next] = dir
+
+			if goal(next) {
+				return walkBack(start, next, from), true
+			}
+			queue = append(queue, next)
+		}
+	}
+
+	return nil, false
+}
+
+func walkBack(start, end MapPosition, from map[MapPosition]Direction) []Direction {
+	dirs := make([]Direction, 0)
+	for pos := end; pos != start; {
+		dir := from[pos]
+		dirs = append([]Direction{dir}, dirs...)
+		pos = pos.Neighbor(opposite(dir))
+	}
+	return dirs
+}
+
+type pushState struct {
+	player  MapPosition
+	boulder MapPosition
+}
+
+type pushStep struct {
+	prev pushState
+	dir  Direction
+	push bool
+}
+
+// pushToPlate searches for the shortest way to push one of the boulders onto
+// plate, walking and pushing like the human does.
+func (b *Bot) pushToPlate(g *Game, plate MapPosition) (ActionType, bool) {
+	start := g.playerState[b.player].mapPos
+
+	positions := make([]MapPosition, 0, len(g.boulders))
+	for pos, _ := range g.boulders {
+		positions = append(positions, pos)
+	}
+	sort.Slice(positions, func(i, j int) bool { return g.boulders[positions[i]].id < g.boulders[positions[j]].id })
+
+	for _, boulderPos := range positions {
+		boulder := g.boulders[boulderPos]
+		if !boulder.active || !g.PlayerCanPush(b.player, boulder) {
+			continue
+		}
+		if _, moving := g.boulderTransition[boulder]; moving {
+			continue
+		}
+
+		free := func(pos MapPosition, s pushState) bool {
+			if pos == s.boulder {
+				return false
+			}
+			if pos == boulderPos {
+				return b.inside(g, pos) && !b.partnerAt(g, pos)
+			}
+			return b.passable(g, pos, false)
+		}
+
+		// the boulder needs an empty cell, an open door does not stop it
+		pushable := func(pos MapPosition) bool {
+			if !b.inside(g, pos) || g.IsWall(pos) || b.partnerAt(g, pos) {
+				return false
+			}
+			if door, ok := g.doors[pos]; ok && !door.isOpen {
+				return false
+			}
+			if _, ok := g.boulders[pos]; ok && pos != boulderPos {
+				return false
+			}
+			return true
+		}
+
+		first := pushState{start, boulderPos}
+		steps := map[pushState]pushStep{}
+		visited := map[pushState]bool{first: true}
+		queue := []pushState{first}
+
+		for len(queue) > 0 {
+			s := queue[0]
+			queue = queue[1:]
+
+			if s.boulder == plate {
+				return b.firstPush(g, first, s, steps), true
+			}
+
+			for _, dir := range Dirs() {
+				next := s
+				step := pushStep{prev: s, dir: dir}
+				if neighbor := s.player.Neighbor(dir); neighbor == s.boulder {
+					// on a trigger cell the action uses the trigger
+					if _, ok := g.triggers[s.player]; ok || !pushable(s.boulder.Neighbor(dir)) {
+						continue
+					}
+					next.boulder = s.boulder.Neighbor(dir)
+					step.push = true
+				} else if free(neighbor, s) {
+					next.player = neighbor
+				} else {
+					continue
+				}
+
+				if !visited[next] {
+					visited[next] = true
+					steps[next] = step
+					queue = append(queue, next)
+				}
+			}
+		}
+	}
+
+	return ActionNoAction, false
+}
+
+func (b *Bot) firstPush(g *Game, first, last pushState, steps map[pushState]pushStep) ActionType {
+	if first == last {
+		return ActionNoAction
+	}
+
+	s := last
+	step := steps[s]
+	for step.prev != first {
+		s = step.prev
+		step = steps[s]
+	}
+
+	if !step.push {
+		return moveAction(step.dir)
+	}
+	if g.PlayerDirection(b.player) != step.dir {
+		return lookAction(step.dir)
+	}
+	return ActionAction
+}
+
+func opposite(dir Direction) Direction {
+	switch dir {
+	case DirNorth:
+		return DirSouth
+	case DirSouth:
+		return DirNorth
+	case DirWest:
+		return DirEast
+	}
+	return DirWest
+}
+
+func moveAction(dir Direction) ActionType {
+	switch dir {
+	case DirNorth:
+		return ActionMoveNorth
+	case DirWest:
+		return ActionMoveWest
+	case DirSouth:
+		return ActionMoveSouth
+	}
+	return ActionMoveEast
+}
+
+func lookAction(dir Direction) ActionType {
+	switch dir {
+	case DirNorth:
+		return ActionLookNorth
+	case DirWest:
+		return ActionLookWest
+	case DirSouth:
+		return ActionLookSouth
+	}
+	return ActionLookEast
+}
@@ -0,0 +1,58 @@
+package game
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+const maxChatLength = 80
+
+type MessageKind int
+
+const (
+	MessageChat   MessageKind = iota
+	MessageMarker             // ping on map cell X, Y
+)
+
+func (k MessageKind) Valid() bool {
+	return k == MessageChat || k == MessageMarker
+}
+
+// Message is a chat line or a map ping. From and Nick are filled in by the
+// server.
+type Message struct {
+	Kind MessageKind
+	From Player
+	Nick string
+	Text string
+	X, Y int
+}
+
+// CleanChatText trims a chat line and checks that it can be shown to the
+// other players.
+func CleanChatText(text string) (string, error) {
+	text = strings.TrimSpace(text)
+
+	if text == "" {
+		return "", errors.New("Empty chat message")
+	}
+
+	if len([]rune(text)) > maxChatLength {
+		return "", errors.New("Chat message too long")
+	}
+
+	for _, r := range text {
+		if !unicode.IsPrint(r) {
+			return "", errors.New("Chat message contains invalid characters")
+		}
+	}
+
+	return text, nil
+}
+
+// CanAppendChat tells whether r may be typed into a chat line of the given
+// length.
+func CanAppendChat(length int, r rune) bool {
+	return length < maxChatLength && unicode.IsPrint(r)
+}
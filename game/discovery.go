@@ -0,0 +1,120 @@
+package game
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net"
+	"strconv"
+	"time"
+)
+
+const discoveryMagic = "laby-discovery-1"
+
+type DiscoveryRequest struct {
+	Magic string
+}
+
+type ServerInfo struct {
+	Magic   string
+	Name    string
+	Addr    string // filled in by the client from the sender address
+	Port    int
+	Players int
+	Started bool
+}
+
+func (si ServerInfo) HostPort() string {
+	return net.JoinHostPort(si.Addr, strconv.Itoa(si.Port))
+}
+
+func encodePacket(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func decodePacket(packet []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(packet)).Decode(v)
+}
+
+// ServeDiscovery answers discovery broadcasts on the given UDP port. info is
+// called for every request so the answer reflects the current session.
+func ServeDiscovery(port int, info func() ServerInfo) error {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+
+		var req DiscoveryRequest
+		if err := decodePacket(buf[:n], &req); err != nil || req.Magic != discoveryMagic {
+			continue
+		}
+
+		resp := info()
+		resp.Magic = discoveryMagic
+		packet, err := encodePacket(resp)
+		if err != nil {
+			netLog.Error("Failed to encode discovery answer", "err", err)
+			continue
+		}
+
+		conn.WriteToUDP(packet, addr)
+	}
+}
+
+// DiscoverServers broadcasts a discovery request on the local network and
+// collects the answers that arrive within timeout.
+func DiscoverServers(port int, timeout time.Duration) ([]ServerInfo, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	packet, err := encodePacket(DiscoveryRequest{Magic: discoveryMagic})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.WriteToUDP(packet, &net.UDPAddr{IP: net.IPv4bcast, Port: port}); err != nil {
+		return nil, err
+	}
+
+	servers := make([]ServerInfo, 0)
+	seen := make(map[string]bool)
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return servers, nil
+			}
+			return servers, err
+		}
+
+		var info ServerInfo
+		if err := decodePacket(buf[:n], &info); err != nil || info.Magic != discoveryMagic {
+			continue
+		}
+
+		info.Addr = addr.IP.String()
+		if seen[info.HostPort()] {
+			continue
+		}
+		seen[info.HostPort()] = true
+
+		servers = append(servers, info)
+	}
+}
@@ -0,0 +1,85 @@
+package game
+
+import (
+	"strconv"
+)
+
+// DenyReason tells a client why its action was refused. The server sends it
+// after ServerActionDenied.
+type DenyReason int
+
+const (
+	DenyOther          DenyReason = iota
+	DenyWall                      // walked into a wall
+	DenyBlocked                   // the cell is taken and stays taken
+	DenyPlayerOnField             // the partner stands there
+	DenyBusy                      // still walking or acting
+	DenyInTransition              // the lever or its door is still moving
+	DenyNotYourLever              // only the partner can pull it
+	DenyNotYourBoulder            // only the partner can push it
+	DenyBoulderMoving             // the boulder is still rolling
+	DenyBoulderBlocked            // nothing behind the boulder to push it into
+	DenyNothingToDo               // no lever or boulder there
+	DenyAlreadyVisible
+	DenyVisibilityDelay
+	DenyUnknownAction
+	DenyTooFast
+	DenyGameStarted
+	DenyNothingToSave // no game running with both players
+	DenyNoPlayerId    // a player has no persistent id to find the save with
+	DenyBotPartner    // sessions with a bot are not saved
+)
+
+var denyNames = []string{
+	"other", "wall", "blocked", "player-on-field", "busy", "in-transition",
+	"not-your-lever", "not-your-boulder", "boulder-moving", "boulder-blocked",
+	"nothing-to-do", "already-visible", "visibility-delay", "unknown-action",
+	"too-fast", "game-started", "nothing-to-save", "no-player-id", "bot-partner",
+}
+
+func (r DenyReason) String() string {
+	if r < 0 || int(r) >= len(denyNames) {
+		return "deny" + strconv.Itoa(int(r))
+	}
+	return denyNames[r]
+}
+
+// An ActionError is returned when the game refuses an action.
+type ActionError struct {
+	Reason DenyReason
+	msg    string
+}
+
+func (e *ActionError) Error() string {
+	return e.msg
+}
+
+var (
+	ErrWall            = &ActionError{DenyWall, "Is Wall"}
+	ErrBlocked         = &ActionError{DenyBlocked, "Is not empty and will not be empty"}
+	ErrPlayerOnField   = &ActionError{DenyPlayerOnField, "Player on field"}
+	ErrBusy            = &ActionError{DenyBusy, "Player in action"}
+	ErrInTransition    = &ActionError{DenyInTransition, "Not possible"}
+	ErrNotYourLever    = &ActionError{DenyNotYourLever, "Not authorized"}
+	ErrNotYourBoulder  = &ActionError{DenyNotYourBoulder, "Not authorized"}
+	ErrBoulderMoving   = &ActionError{DenyBoulderMoving, "Boulder already moving"}
+	ErrBoulderBlocked  = &ActionError{DenyBoulderBlocked, "Is not empty and will not be empty"}
+	ErrNothingToDo     = &ActionError{DenyNothingToDo, "No action"}
+	ErrAlreadyVisible  = &ActionError{DenyAlreadyVisible, "Already visible"}
+	ErrVisibilityDelay = &ActionError{DenyVisibilityDelay, "Delay"}
+	ErrUnknownAction   = &ActionError{DenyUnknownAction, "Unknown action"}
+	ErrTooFast         = &ActionError{DenyTooFast, "Acting too fast"}
+	ErrGameStarted     = &ActionError{DenyGameStarted, "Game already started"}
+	ErrNothingToSave   = &ActionError{DenyNothingToSave, "No game to save"}
+	ErrNoPlayerId      = &ActionError{DenyNoPlayerId, "Saving needs a player id for both players"}
+	ErrBotPartner      = &ActionError{DenyBotPartner, "Sessions with a bot are not saved"}
+)
+
+// ReasonOf returns why an action was refused, DenyOther if err is not an
+// ActionError.
+func ReasonOf(err error) DenyReason {
+	if e, ok := err.(*ActionError); ok {
+		return e.Reason
+	}
+	return DenyOther
+}
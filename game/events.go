@@ -0,0 +1,44 @@
+package game
+
+// Kinds of the changes to the level a game reports.
+const (
+	EventTrigger = "trigger" // a lever was pulled, Active is its new state
+	EventDoor    = "door"    // Active tells whether the door is open now
+	EventPlate   = "plate"   // something weighs the plate down
+	EventRoom    = "room"    // a room became visible
+	EventBlocked = "blocked" // a move or push was resolved to stay, Id is the DenyReason
+
+	EventLevelCompleted = "level-completed" // both players reached the goal room, Id is the room
+)
+
+// A GameEvent is a change to the level, for the server's audit log.
+type GameEvent struct {
+	Tick   Tick
+	Kind   string
+	Id     int
+	Active bool
+	Player Player // who pulled the trigger or was blocked, Observer for the other kinds
+}
+
+// RecordEvents makes the game collect its events until they are taken.
+func (g *Game) RecordEvents() {
+	g.events = make([]GameEvent, 0)
+}
+
+// TakeEvents returns the events since the last call.
+func (g *Game) TakeEvents() []GameEvent {
+	if g.events == nil {
+		return nil
+	}
+
+	events := g.events
+	g.events = make([]GameEvent, 0)
+	return events
+}
+
+func (g *Game) event(kind string, id int, active bool, player Player) {
+	if g.events == nil {
+		return
+	}
+	g.events = append(g.events, GameEvent{Tick: g.tick, Kind: kind, Id: id, Active: active, Player: player})
+}
@@ -6,13 +6,14 @@ import (
 	"errors"
 	"github.com/banthar/Go-SDL/mixer"
 	"github.com/banthar/Go-SDL/sdl"
-	"log"
+	"sort"
 	"time"
 )
 
 const Ghost Player = Player(1)
 const Human Player = Player(0)
 const GhostOrHuman Player = Player(-1)
+const Observer Player = Player(-2) // sees everything, used for spectators
 
 func FillRect(x0, y0, x1, y1 int, pos []MapPosition) []MapPosition {
 	for y := y0; y <= y1; y++ {
@@ -24,337 +25,80 @@ func FillRect(x0, y0, x1, y1 int, pos []MapPosition) []MapPosition {
 	return pos
 }
 
-func NewMapConfig() *MapConfig {
-	walls := []MapPosition{
-		MapPosition{0, 0},
-		MapPosition{0, 1},
-		MapPosition{0, 2},
-		MapPosition{0, 3},
-		MapPosition{0, 4},
-		MapPosition{0, 5},
-		MapPosition{0, 6},
-		MapPosition{0, 7},
-		MapPosition{0, 8},
-		MapPosition{0, 9},
-		MapPosition{0, 10},
-		MapPosition{0, 11},
-		MapPosition{0, 12},
-		MapPosition{0, 13},
-		MapPosition{0, 14},
-		MapPosition{0, 15},
-		MapPosition{0, 16},
-
-		MapPosition{1, 0},
-		MapPosition{2, 0},
-		MapPosition{3, 0},
-		MapPosition{4, 0},
-		MapPosition{5, 0},
-		MapPosition{6, 0},
-		MapPosition{7, 0},
-		MapPosition{8, 0},
-		MapPosition{9, 0},
-		MapPosition{10, 0},
-		MapPosition{11, 0},
-		MapPosition{12, 0},
-		MapPosition{13, 0},
-		MapPosition{14, 0},
-
-		MapPosition{14, 0},
-		MapPosition{14, 1},
-		MapPosition{14, 2},
-		MapPosition{14, 3},
-		MapPosition{14, 4},
-		MapPosition{14, 5},
-		MapPosition{14, 6},
-		MapPosition{14, 7},
-		MapPosition{14, 8},
-		MapPosition{14, 9},
-		MapPosition{14, 10},
-		MapPosition{14, 11},
-		MapPosition{14, 12},
-		MapPosition{14, 13},
-		MapPosition{14, 14},
-		MapPosition{14, 15},
-		MapPosition{14, 16},
-
-		MapPosition{1, 16},
-		MapPosition{2, 16},
-		MapPosition{3, 16},
-		MapPosition{4, 16},
-		MapPosition{5, 16},
-		MapPosition{6, 16},
-		MapPosition{7, 16},
-		MapPosition{8, 16},
-		MapPosition{9, 16},
-		MapPosition{10, 16},
-		MapPosition{11, 16},
-		MapPosition{12, 16},
-		MapPosition{13, 16},
-
-		MapPosition{1, 1},
-		MapPosition{1, 2},
-		MapPosition{1, 4},
-		MapPosition{1, 5},
-		MapPosition{1, 6},
-		MapPosition{2, 6},
-		MapPosition{3, 6},
-		MapPosition{4, 6},
-		MapPosition{5, 6},
-		MapPosition{6, 6},
-		MapPosition{7, 6},
-		MapPosition{8, 6},
-		MapPosition{8, 4},
-		MapPosition{8, 3},
-		MapPosition{8, 2},
-		MapPosition{8, 1},
-
-		MapPosition{8, 7},
-		MapPosition{8, 8},
-		MapPosition{8, 9},
-		MapPosition{8, 10},
-		MapPosition{8, 12},
-		MapPosition{8, 13},
-
-		MapPosition{8, 13},
-		MapPosition{7, 13},
-		MapPosition{6, 13},
-		MapPosition{5, 13},
-		MapPosition{3, 13},
-		MapPosition{1, 13},
-
-		MapPosition{1, 12},
-		MapPosition{1, 11},
-
-		MapPosition{3, 12},
-		MapPosition{3, 11},
-
-		MapPosition{5, 12},
-		MapPosition{5, 11},
-
-		MapPosition{6, 14},
-		MapPosition{6, 15},
-
-		MapPosition{9, 10},
-		MapPosition{10, 10},
-
-		MapPosition{10, 11},
-		MapPosition{10, 12},
-		MapPosition{10, 13},
-		MapPosition{10, 15},
-
-		MapPosition{11, 13},
-		MapPosition{13, 13},
-		MapPosition{12, 15},
-
-		MapPosition{9, 8},
-		MapPosition{11, 8},
-		MapPosition{12, 8},
-		MapPosition{13, 8},
-
-		MapPosition{5, 5},
-		MapPosition{5, 4},
-		MapPosition{5, 3},
-
-		MapPosition{7, 3},
-
-		MapPosition{3, 14},
-		MapPosition{3, 15},
-	}
-
-	triggers := []CfgTriggerData{
-		// triggerID doorID bannID
-		NewCfgTriggerData(1, 2, -1, NewMapPosition(4, 15), DirWest, Human, GhostOrHuman, -1),
-		NewCfgTriggerData(2, 1, -1, NewMapPosition(2, 15), DirWest, Ghost, GhostOrHuman, -1),
-		// boulder drop
-		NewCfgTriggerData(3, -1, -1, NewMapPosition(7, 12), DirWest, Human, GhostOrHuman, 2), // x1
-		// NewCfgTriggerData(3, 3, -1, NewMapPosition(7, 12), DirEast, Human, GhostOrHuman, 2), // x1
-
-		NewCfgTriggerData(5, 6, -1, NewMapPosition(11, 12), DirWest, Human, Ghost, -1),        // g4
-		NewCfgTriggerData(6, 5, -1, NewMapPosition(13, 11), DirWest, Ghost, GhostOrHuman, -1), // g5
-		NewCfgTriggerData(7, 7, -1, NewMapPosition(13, 5), DirWest, Human, GhostOrHuman, -1),
-
-		// inactive
-		NewCfgTriggerData(8, -1, -1, NewMapPosition(13, 1), DirWest, Ghost, Human, -1),
-
-		NewCfgTriggerData(9, -1, -1, NewMapPosition(2, 1), DirWest, Human, Ghost, -1),
-		NewCfgTriggerData(10, -1, -1, NewMapPosition(2, 5), DirWest, GhostOrHuman, Human, -1),
-		NewCfgTriggerData(11, -1, -1, NewMapPosition(7, 1), DirWest, Human, GhostOrHuman, -1),
-
-		// mid down
-		// NewCfgTriggerData(12, -1, -1, NewMapPosition(8, 15), DirSouth, Ghost, Human),
-		NewCfgTriggerData(12, 4, -1, NewMapPosition(8, 15), DirWest, Ghost, Human, -1),
-	}
-
-	plates := []CfgPlateData{
-		NewCfgPlateData(1, -1, -1, NewMapPosition(1, 10)),
-		NewCfgPlateData(1, 3, -1, NewMapPosition(7, 7)),
-	}
-
-	doors := []CfgDoorData{
-		NewCfgDoorData(1, 3, NewMapPosition(2, 13)),
-		NewCfgDoorData(2, 3, NewMapPosition(4, 13)),
-		NewCfgDoorData(3, 4, NewMapPosition(8, 11)),
-		NewCfgDoorData(4, 7, NewMapPosition(10, 14)), /// t4
-		NewCfgDoorData(5, 5, NewMapPosition(12, 13)), // t5
-		NewCfgDoorData(6, 6, NewMapPosition(10, 8)),  // t6
-		NewCfgDoorData(7, 8, NewMapPosition(8, 5)),
-		NewCfgDoorData(8, 9, NewMapPosition(6, 3)),
-		NewCfgDoorData(9, -1, NewMapPosition(1, 3)),
-	}
-
-	boulders := []CfgBoulderData{
-		NewCfgBoulderData(1, true, NewMapPosition(4, 12)),
-		NewCfgBoulderData(2, false, NewMapPosition(3, 8)),
-	}
+// newMapConfig builds the data of the level BuildGame puts on the map. The
+// level has been checked.
+func newMapConfig(level *Level) *MapConfig {
+	cfg := &MapConfig{
+		level:     level,
+		levelId:   level.Id,
+		goalRoom:  level.GoalRoom,
+		mapWidth:  level.Width,
+		mapHeight: level.Height,
+		outline:   level.IsOutline(),
 
-	bannWalls := []CfgBannWallData{
-		NewCfgBannWallData(1, NewMapPosition(3, 1), 0),
-		NewCfgBannWallData(2, NewMapPosition(4, 1), 1),
-		NewCfgBannWallData(3, NewMapPosition(3, 2), 2),
-		NewCfgBannWallData(4, NewMapPosition(4, 2), 3),
-
-		NewCfgBannWallData(5, NewMapPosition(5, 1), 0),
-		NewCfgBannWallData(6, NewMapPosition(6, 1), 1),
-		NewCfgBannWallData(7, NewMapPosition(5, 2), 2),
-		NewCfgBannWallData(8, NewMapPosition(6, 2), 3),
-
-		NewCfgBannWallData(9, NewMapPosition(3, 3), 0),
-		NewCfgBannWallData(10, NewMapPosition(4, 3), 1),
-		NewCfgBannWallData(11, NewMapPosition(3, 4), 2),
-		NewCfgBannWallData(13, NewMapPosition(4, 4), 3),
+		rooms:     make(map[RoomID]*Room),
+		plates:    make(map[PlateID]*Plate),
+		triggers:  make(map[TriggerID]*Trigger),
+		doors:     make(map[DoorID]*Door),
+		boulders:  make(map[BoulderID]*Boulder),
+		bannWalls: make(map[BannWallID]*BannWall),
 	}
+	cfg.walkTime, _ = time.ParseDuration(level.WalkTime)
+	cfg.rollTime, _ = time.ParseDuration(level.RollTime)
+	cfg.actionTime, _ = time.ParseDuration(level.ActionTime)
 
-	room3 := make([]MapPosition, 0)
-	room3 = FillRect(0, 6, 8, 13, room3)
-
-	room4 := make([]MapPosition, 0)
-	room4 = FillRect(6, 13, 10, 16, room4)
-	room4 = FillRect(8, 10, 10, 13, room4)
-
-	room5 := make([]MapPosition, 0)
-	room5 = FillRect(8, 8, 14, 10, room5)
-	room5 = FillRect(10, 10, 14, 13, room5)
-
-	room7 := make([]MapPosition, 0)
-	room7 = FillRect(10, 13, 14, 16, room7)
-
-	room6 := make([]MapPosition, 0)
-	room6 = FillRect(8, 0, 14, 8, room6)
-
-	room8 := make([]MapPosition, 0)
-	room8 = FillRect(5, 3, 8, 6, room8)
-
-	room9 := make([]MapPosition, 0)
-	room9 = FillRect(0, 0, 5, 6, room9)
-	room9 = FillRect(5, 0, 8, 3, room9)
-
-	rooms := []CfgRoomData{
-		NewCfgRoomData(1, []MapPosition{
-			MapPosition{0, 16},
-			MapPosition{1, 16},
-			MapPosition{2, 16},
-			MapPosition{3, 16},
-
-			MapPosition{0, 15},
-			MapPosition{1, 15},
-			MapPosition{2, 15},
-			MapPosition{3, 15},
-
-			MapPosition{0, 14},
-			MapPosition{1, 14},
-			MapPosition{2, 14},
-			MapPosition{3, 14},
-
-			MapPosition{0, 13},
-			MapPosition{1, 13},
-			MapPosition{2, 13},
-			MapPosition{3, 13},
-		}),
-
-		NewCfgRoomData(2, []MapPosition{
-			MapPosition{0 + 3, 16},
-			MapPosition{1 + 3, 16},
-			MapPosition{2 + 3, 16},
-			MapPosition{3 + 3, 16},
-
-			MapPosition{0 + 3, 15},
-			MapPosition{1 + 3, 15},
-			MapPosition{2 + 3, 15},
-			MapPosition{3 + 3, 15},
-
-			MapPosition{0 + 3, 14},
-			MapPosition{1 + 3, 14},
-			MapPosition{2 + 3, 14},
-			MapPosition{3 + 3, 14},
-
-			MapPosition{0 + 3, 13},
-			MapPosition{1 + 3, 13},
-			MapPosition{2 + 3, 13},
-			MapPosition{3 + 3, 13},
-		}),
-
-		NewCfgRoomData(3, room3),
+	for _, start := range level.Starts {
+		cfg.playerStartPos = append(cfg.playerStartPos, start.Pos.MapPosition())
+		cfg.playerStartLook = append(cfg.playerStartLook, start.LooksIn)
+	}
 
-		NewCfgRoomData(4, room4),
-		NewCfgRoomData(5, room5),
-		NewCfgRoomData(6, room6),
-		NewCfgRoomData(7, room7),
-		NewCfgRoomData(8, room8),
-		NewCfgRoomData(9, room9),
+	for y, row := range level.Walls {
+		for x, c := range row {
+			if c == '#' {
+				cfg.walls = append(cfg.walls, MapPosition{x, y})
+			}
+		}
 	}
 
-	doorMu := mixer.LoadMUS("data/door.ogg")
-	if doorMu == nil {
-		log.Println(sdl.GetError())
+	for _, t := range level.Triggers {
+		cfg.triggerData = append(cfg.triggerData, NewCfgTriggerData(t.ID, t.Door, t.BannWall, t.Pos.MapPosition(), t.Dir, t.CanTrigger, t.CanSee, t.Boulder))
 	}
-
-	triggerMu1 := mixer.LoadMUS("data/trigger1.wav")
-	if triggerMu1 == nil {
-		log.Println(sdl.GetError())
+	for _, p := range level.Plates {
+		cfg.plateData = append(cfg.plateData, NewCfgPlateData(p.ID, p.Door, p.BannWall, p.Pos.MapPosition()))
 	}
-
-	triggerMu2 := mixer.LoadMUS("data/trigger2.wav")
-	if triggerMu2 == nil {
-		log.Println(sdl.GetError())
+	for _, d := range level.Doors {
+		cfg.doorData = append(cfg.doorData, NewCfgDoorData(d.ID, d.Room, d.Pos.MapPosition()))
+	}
+	for _, b := range level.Boulders {
+		cfg.boulderData = append(cfg.boulderData, NewCfgBoulderData(b.ID, b.Active, b.Pos.MapPosition()))
+	}
+	for _, bw := range level.BannWalls {
+		cfg.bannWallData = append(cfg.bannWallData, NewCfgBannWallData(bw.ID, bw.Pos.MapPosition(), bw.Type))
+	}
+	for _, r := range level.Rooms {
+		cells := make([]MapPosition, 0)
+		for _, rect := range r.Rects {
+			cells = FillRect(rect.From.X, rect.From.Y, rect.To.X, rect.To.Y, cells)
+		}
+		cfg.roomData = append(cfg.roomData, NewCfgRoomData(r.ID, cells, r.Visible))
 	}
 
-	return &MapConfig{
-		playerStartPos: []MapPosition{
-			MapPosition{5, 15},
-			MapPosition{1, 15},
-		},
-		playerStartLook: []Direction{
-			DirEast,
-			DirWest,
-		},
-
-		walkTime:   200 * time.Millisecond,
-		rollTime:   200 * time.Millisecond,
-		actionTime: 200 * time.Millisecond,
-
-		mapWidth:  15,
-		mapHeight: 17,
-
-		walls: walls,
-
-		triggerData:  triggers,
-		plateData:    plates,
-		doorData:     doors,
-		boulderData:  boulders,
-		bannWallData: bannWalls,
-		roomData:     rooms,
+	cfg.doorMusic = mixer.LoadMUS("data/door.ogg")
+	if cfg.doorMusic == nil {
+		audioLog.Warn("Failed to load sound", "file", "data/door.ogg", "err", sdl.GetError())
+	}
 
-		rooms:     make(map[RoomID]*Room),
-		plates:    make(map[PlateID]*Plate),
-		triggers:  make(map[TriggerID]*Trigger),
-		doors:     make(map[DoorID]*Door),
-		boulders:  make(map[BoulderID]*Boulder),
-		bannWalls: make(map[BannWallID]*BannWall),
+	cfg.trigger1Music = mixer.LoadMUS("data/trigger1.wav")
+	if cfg.trigger1Music == nil {
+		audioLog.Warn("Failed to load sound", "file", "data/trigger1.wav", "err", sdl.GetError())
+	}
 
-		doorMusic:     doorMu,
-		trigger1Music: triggerMu1,
-		trigger2Music: triggerMu2,
+	cfg.trigger2Music = mixer.LoadMUS("data/trigger2.wav")
+	if cfg.trigger2Music == nil {
+		audioLog.Warn("Failed to load sound", "file", "data/trigger2.wav", "err", sdl.GetError())
 	}
+
+	return cfg
 }
 
 type CellType int
@@ -365,7 +109,8 @@ const (
 	CellTypeDoor
 )
 
-var GlobalConfig *MapConfig = NewMapConfig()
+// GlobalConfig is the level games are built from, LoadLevel sets it.
+var GlobalConfig *MapConfig
 
 type RoomID int
 type TriggerID int
@@ -399,8 +144,9 @@ type CfgDoorData struct {
 }
 
 type CfgRoomData struct {
-	id    RoomID
-	cells []MapPosition
+	id      RoomID
+	cells   []MapPosition
+	visible bool
 }
 
 type CfgBoulderData struct {
@@ -461,14 +207,20 @@ func NewCfgDoorData(id DoorID, room RoomID, pos MapPosition) CfgDoorData {
 	}
 }
 
-func NewCfgRoomData(id RoomID, cells []MapPosition) CfgRoomData {
+func NewCfgRoomData(id RoomID, cells []MapPosition, visible bool) CfgRoomData {
 	return CfgRoomData{
-		id:    id,
-		cells: cells,
+		id:      id,
+		cells:   cells,
+		visible: visible,
 	}
 }
 
 type MapConfig struct {
+	level    *Level
+	outline  bool   // nothing but the size and timing, the map is hidden
+	levelId  string // changes whenever the layout changes, saves refer to it
+	goalRoom RoomID // the level is completed once both players are in it
+
 	playerStartPos  []MapPosition
 	playerStartLook []Direction
 
@@ -508,16 +260,19 @@ func BuildGame(g *Game) {
 
 	for _, doorData := range GlobalConfig.doorData {
 		door := g.SetDoor(doorData.pos)
+		door.id = doorData.id
 		GlobalConfig.doors[doorData.id] = door
 	}
 
 	for _, boulderData := range GlobalConfig.boulderData {
 		boulder := g.SetBoulder(boulderData.pos, boulderData.spawned)
+		boulder.id = boulderData.id
 		GlobalConfig.boulders[boulderData.id] = boulder
 	}
 
 	for _, plateData := range GlobalConfig.plateData {
 		plate := g.SetPlate(plateData.pos)
+		plate.id = plateData.id
 		GlobalConfig.plates[plateData.id] = plate
 	}
 
@@ -527,25 +282,24 @@ func BuildGame(g *Game) {
 			boulder = GlobalConfig.boulders[triggerData.targetBoulder]
 		}
 
-		log.Println("Init boulder", boulder)
-
 		trigger := g.SetTrigger(triggerData.pos, triggerData.dir, triggerData.canTrigger, triggerData.canVis, boulder)
+		trigger.id = triggerData.id
 		GlobalConfig.triggers[triggerData.id] = trigger
 	}
 
 	for _, roomData := range GlobalConfig.roomData {
 		room := g.NewRoom(roomData.cells)
+		room.id = roomData.id
+		room.isVisible = roomData.visible
 		GlobalConfig.rooms[roomData.id] = room
 	}
 
 	for _, bannWallData := range GlobalConfig.bannWallData {
 		bannWall := g.SetBannWall(bannWallData.pos, bannWallData.bannWallType)
+		bannWall.id = bannWallData.id
 		GlobalConfig.bannWalls[bannWallData.id] = bannWall
 	}
 
-	GlobalConfig.rooms[1].isVisible = true
-	GlobalConfig.rooms[2].isVisible = true
-
 	ConnectEverything(g)
 }
 
@@ -590,10 +344,20 @@ func ConnectEverything(g *Game) {
 type Player int
 
 type Room struct {
+	id        RoomID
 	isVisible bool
 	cells     []MapPosition
 }
 
+func (r *Room) Contains(pos MapPosition) bool {
+	for _, cell := range r.cells {
+		if cell == pos {
+			return true
+		}
+	}
+	return false
+}
+
 func (g *Game) NewRoom(cells []MapPosition) *Room {
 	r := &Room{
 		isVisible: false,
@@ -606,11 +370,15 @@ func (g *Game) NewRoom(cells []MapPosition) *Room {
 }
 
 type Door struct {
+	id         DoorID
 	isOpen     bool
 	linkedRoom *Room
 }
 
 func (g *Game) MakeRoomVisible(room *Room) {
+	if !room.isVisible {
+		g.event(EventRoom, int(room.id), true, Observer)
+	}
 	room.isVisible = true
 	for _, player := range g.players {
 		for _, cellPos := range room.cells {
@@ -620,6 +388,7 @@ func (g *Game) MakeRoomVisible(room *Room) {
 }
 
 func (g *Game) ToggleDoor(d *Door) {
+	g.event(EventDoor, int(d.id), !d.isOpen, Observer)
 	if d.isOpen {
 		// d.linkedRoom.isVisible = false
 		d.isOpen = false
@@ -647,6 +416,7 @@ func NewDoor() *Door {
 }
 
 type Trigger struct {
+	id             TriggerID
 	isActive       bool
 	staysActive    time.Duration
 	linkedDoor     *Door
@@ -676,6 +446,7 @@ func NewTrigger(canTrigger, canVis Player, boulder *Boulder) *Trigger {
 }
 
 type Boulder struct {
+	id     BoulderID
 	active bool
 }
 
@@ -690,6 +461,7 @@ func (b *Boulder) IsActive() bool {
 }
 
 type Plate struct {
+	id             PlateID
 	isActive       bool
 	linkedBannWall *BannWall
 	linkedDoor     *Door
@@ -704,6 +476,7 @@ func NewPlate() *Plate {
 }
 
 type BannWall struct {
+	id           BannWallID
 	isActive     bool
 	bannWallType int
 }
@@ -747,7 +520,7 @@ func (mp MapPosition) Neighbor(direction Direction) MapPosition {
 	case DirEast:
 		return MapPosition{mp.x + 1, mp.y}
 	}
-	log.Fatal("Not reached")
+	gameLog.Fatal("Not reached")
 	return MapPosition{-1, -1}
 }
 
@@ -833,6 +606,10 @@ const (
 	DirEast
 )
 
+func (d Direction) Valid() bool {
+	return d >= DirNorth && d <= DirEast
+}
+
 func Dirs() []Direction {
 	dirs := make([]Direction, 4)
 	dirs[0] = DirNorth
@@ -910,40 +687,31 @@ func (g *Game) PlayerWalkFrame(player Player) int {
 func (g *Game) PerformPlayerAction(player Player, action ActionType) error {
 	switch action {
 	case ActionMoveNorth:
-		log.Println("Move player")
 		return g.PlayerMove(player, DirNorth)
 	case ActionMoveEast:
-		log.Println("Move player")
 		return g.PlayerMove(player, DirEast)
 	case ActionMoveSouth:
-		log.Println("Move player")
 		return g.PlayerMove(player, DirSouth)
 	case ActionMoveWest:
-		log.Println("Move player")
 		return g.PlayerMove(player, DirWest)
 
 	case ActionLookNorth:
-		log.Println("Player look")
 		return g.PlayerLookIn(player, DirNorth)
 	case ActionLookEast:
-		log.Println("Player look")
 		return g.PlayerLookIn(player, DirEast)
 	case ActionLookSouth:
-		log.Println("Player look")
 		return g.PlayerLookIn(player, DirSouth)
 	case ActionLookWest:
-		log.Println("Player look")
 		return g.PlayerLookIn(player, DirWest)
 
 	case ActionAction:
 		return g.PlayerAction(player, g.playerState[player].looksIn) // action in look direction
 	case ActionToggleVisibility:
 		return g.PlayerSetOtherVisible(player)
-	case ActionNoAction:
+	case ActionNoAction, ActionPlayerReady: // the server handles ready
 		return nil
 	}
-	log.Fatal("Not reached")
-	return nil
+	return ErrUnknownAction
 }
 
 type VisStateTransition struct {
@@ -1003,11 +771,11 @@ func (g *Game) PlayerSetOtherVisible(player Player) error {
 	// }
 
 	if _, ok := g.playerVisTransition[player]; ok {
-		return errors.New("Already visible")
+		return ErrAlreadyVisible
 	}
 
 	if _, ok := g.visDelay[player]; ok {
-		return errors.New("Delay")
+		return ErrVisibilityDelay
 	}
 
 	g.playerVisTransition[player] = NewVisStateTransition(player)
@@ -1026,9 +794,12 @@ func (g *Game) PlayerDirection(player Player) Direction {
 	return g.playerState[player].looksIn
 }
 
+// PlayerMove asks for a move, it is resolved with the other moves of the tick
+// (see resolve.go). Only what the other actions of the tick cannot change is
+// checked right away.
 func (g *Game) PlayerMove(player Player, direction Direction) error {
-	if _, ok := g.playerMoveTransition[player]; ok {
-		return errors.New("Player in action")
+	if _, ok := g.playerMoveTransition[player]; ok || g.HasIntent(player) {
+		return ErrBusy
 	}
 
 	playerPos := g.playerState[player].mapPos
@@ -1036,44 +807,19 @@ func (g *Game) PlayerMove(player Player, direction Direction) error {
 
 	targetCell := g.gameMap.Cell(targetPos)
 	if targetCell.IsWall() {
-		return errors.New("Is Wall")
-	}
-
-	if !g.IsEmpty(targetPos) {
-		if g.IsDoor(targetPos) && g.Door(targetPos).IsOpen() {
-			// door is open
-		} else if g.IsDoor(targetPos) && g.PlayerCanPassDoor(player, g.doors[targetPos]) {
-			// block door close
-		} else if g.IsBannWall(targetPos) && g.PlayerCanPassBannWall(player, g.bannWalls[targetPos]) {
-			log.Println("Bann wall passable")
-		} else if g.IsBoulder(targetPos) && g.PlayerCanPassBoulder(player, g.boulders[targetPos]) {
-			log.Println("Boulder passable")
-		} else if g.PosEmptyInFuture(targetPos) {
-			log.Println("Will be empty - something moving away nothing in")
-		} else {
-			return errors.New("Is not empty and will not be empty")
-		}
-
-		if g.IsPlayer(targetPos) {
-			return errors.New("Player on field")
-		} else {
-
-		}
-
+		return ErrWall
 	}
 
-	g.playerMoveTransition[player] =
-		NewPlayerMoveTransition(player, playerPos, targetPos)
-
+	g.intents = append(g.intents, &intent{player: player, dir: direction})
 	return nil
 }
 
 func (g *Game) ActivateTrigger(player Player, trigger *Trigger) {
-	log.Println("Trigger activated", trigger)
+	gameLog.Debug("Trigger activated", "player", player, "trigger", trigger.id)
 	trigger.isActive = !trigger.isActive
+	g.event(EventTrigger, int(trigger.id), trigger.isActive, player)
 
 	if trigger.linkedDoor != nil {
-		log.Println("Door toogled")
 		g.ToggleDoor(trigger.linkedDoor)
 	}
 
@@ -1104,11 +850,11 @@ func (g *Game) PlayerDoesAction(player Player) bool {
 
 func (g *Game) PlayerAction(player Player, direction Direction) error {
 	if g.PlayerIsWalking(player) {
-		return errors.New("Player in action")
+		return ErrBusy
 	}
 
-	if g.PlayerDoesAction(player) {
-		return errors.New("Player in action")
+	if g.PlayerDoesAction(player) || g.HasIntent(player) {
+		return ErrBusy
 	}
 
 	playerPos := g.playerState[player].mapPos
@@ -1117,12 +863,12 @@ func (g *Game) PlayerAction(player Player, direction Direction) error {
 	// fast fix
 	if trigger, ok := playerCell.accessibleTriggers[DirWest]; ok {
 		if _, ok := g.triggerTransition[trigger]; ok {
-			return errors.New("Not possible")
+			return ErrInTransition
 			// trigger in transition
 		}
 
 		if _, ok := g.doorTransition[trigger.linkedDoor]; ok {
-			return errors.New("Not possible")
+			return ErrInTransition
 			// door in transition
 		}
 
@@ -1131,7 +877,7 @@ func (g *Game) PlayerAction(player Player, direction Direction) error {
 			g.playerActionTransition[player] = NewPlayerActionTransition(player)
 			return nil
 		} else {
-			return errors.New("Not authorized")
+			return ErrNotYourLever
 		}
 
 		// feedback - cannot do
@@ -1142,38 +888,16 @@ func (g *Game) PlayerAction(player Player, direction Direction) error {
 	if g.IsBoulder(boulderPos) {
 		boulder := g.boulders[boulderPos]
 		if _, ok := g.boulderTransition[boulder]; ok {
-			return errors.New("Boulder already moving")
-		}
-		targetPos := playerPos.Neighbor(direction).Neighbor(direction)
-
-		if _, ok := g.boulderTransition[boulder]; ok {
-			// boulder in transition
-		}
-
-		// is empty
-		if !g.IsEmpty(targetPos) {
-			if g.IsDoor(targetPos) && g.Door(targetPos).IsOpen() {
-				// door is open
-			} else if g.PosEmptyInFuture(targetPos) {
-				// but something moves away from it
-			} else {
-				return errors.New("Is not empty and will not be empty")
-			}
+			return ErrBoulderMoving
 		}
 
-		if g.PlayerCanPush(player, boulder) {
-		} else {
-			return errors.New("Not authorized")
-		}
-
-		g.boulderTransition[boulder] =
-			NewBoulderTransition(boulder, boulderPos, targetPos)
-		g.playerActionTransition[player] = NewPlayerActionTransition(player)
+		// whether it may be pushed is resolved with the moves of the tick
+		g.intents = append(g.intents, &intent{player: player, dir: direction, push: true})
 		return nil
 
 		// feedback - cannot do
 	}
-	return errors.New("No action")
+	return ErrNothingToDo
 }
 
 type PlayerCans struct {
@@ -1196,14 +920,23 @@ type PlayerVis struct {
 }
 
 func (g *Game) PlayerCanSeeOtherPlayer(player Player) bool {
+	if player == Observer {
+		return true
+	}
 	return g.playerVis[player].visPlayer
 }
 
 func (g *Game) PlayerCanSeeCell(player Player, pos MapPosition) bool {
+	if player == Observer {
+		return true
+	}
 	return g.playerVis[player].visCell[pos]
 }
 
 func (g *Game) PlayerCanSeeTrigger(player Player, t *Trigger) bool {
+	if player == Observer {
+		return true
+	}
 	trigPos := NewMapPosition(-1, -1)
 	for pos, trig := range g.triggers {
 		if trig == t {
@@ -1213,6 +946,9 @@ func (g *Game) PlayerCanSeeTrigger(player Player, t *Trigger) bool {
 	return g.playerVis[player].visTrigger[t] && g.PlayerCanSeeCell(player, trigPos)
 }
 func (g *Game) PlayerCanSeeDoor(player Player, d *Door) bool {
+	if player == Observer {
+		return true
+	}
 	trigPos := NewMapPosition(-1, -1)
 	for pos, trig := range g.doors {
 		if trig == d {
@@ -1223,6 +959,9 @@ func (g *Game) PlayerCanSeeDoor(player Player, d *Door) bool {
 }
 
 func (g *Game) PlayerCanSeeBoulder(player Player, b *Boulder) bool {
+	if player == Observer {
+		return true
+	}
 	trigPos := NewMapPosition(-1, -1)
 	for pos, bw2 := range g.boulders {
 		if bw2 == b {
@@ -1233,6 +972,9 @@ func (g *Game) PlayerCanSeeBoulder(player Player, b *Boulder) bool {
 }
 
 func (g *Game) PlayerCanSeePlate(player Player, p *Plate) bool {
+	if player == Observer {
+		return true
+	}
 	trigPos := NewMapPosition(-1, -1)
 	for pos, bw2 := range g.plates {
 		if bw2 == p {
@@ -1243,6 +985,9 @@ func (g *Game) PlayerCanSeePlate(player Player, p *Plate) bool {
 }
 
 func (g *Game) PlayerCanSeeBannWall(player Player, bw *BannWall) bool {
+	if player == Observer {
+		return true
+	}
 	trigPos := NewMapPosition(-1, -1)
 	for pos, bw2 := range g.bannWalls {
 		if bw2 == bw {
@@ -1342,10 +1087,7 @@ func (pmt *PlayerMoveTransition) Update(dt time.Duration) {
 }
 
 func (pmt *PlayerMoveTransition) UpdateGameState(g *Game) {
-	log.Println("Update game state", pmt.player)
-	log.Println("Old pos was", g.playerState[pmt.player].mapPos)
-	log.Println("transition origin", pmt.OriginPos())
-	log.Println("New pos is", pmt.TargetPos())
+	gameLog.Debug("Player moved", "player", pmt.player, "from", pmt.OriginPos(), "to", pmt.TargetPos())
 
 	g.playerState[pmt.player].mapPos = pmt.TargetPos()
 }
@@ -1355,6 +1097,9 @@ func (pmt *PlayerActionTransition) UpdateGameState(g *Game) {
 }
 
 func (dt *DoorTransition) UpdateGameState(g *Game) {
+	if dt.door.isOpen != dt.toState {
+		g.event(EventDoor, int(dt.door.id), dt.toState, Observer)
+	}
 	dt.door.isOpen = dt.toState
 }
 
@@ -1463,6 +1208,9 @@ func (pmt *PlayerMoveTransition) Frame() int {
 }
 
 func (g *Game) SetRoomVisible(room *Room) {
+	if !room.isVisible {
+		g.event(EventRoom, int(room.id), true, Observer)
+	}
 	room.isVisible = true
 	for _, pos := range room.cells {
 		for _, player := range g.players {
@@ -1501,6 +1249,7 @@ func (g *Game) PlateCanBeActivated(pos MapPosition) bool {
 
 func (g *Game) ActivatePlate(pos MapPosition) {
 	plate := g.plates[pos]
+	g.event(EventPlate, int(plate.id), true, Observer)
 	if plate.linkedBannWall != nil {
 		plate.linkedBannWall.isActive = false
 	}
@@ -1510,8 +1259,18 @@ func (g *Game) ActivatePlate(pos MapPosition) {
 	}
 }
 
-func (g *Game) Update(t time.Duration) {
-	for player, moveTransition := range g.playerMoveTransition {
+// simulate advances all transitions by t. Transitions are visited in a fixed
+// order, finishing one may change what the next one finds.
+func (g *Game) simulate(t time.Duration) {
+	players := make([]Player, len(g.players))
+	copy(players, g.players)
+	sort.Slice(players, func(i, j int) bool { return players[i] < players[j] })
+
+	for _, player := range players {
+		moveTransition, ok := g.playerMoveTransition[player]
+		if !ok {
+			continue
+		}
 		moveTransition.Update(t)
 
 		if moveTransition.IsFinished() {
@@ -1526,7 +1285,14 @@ func (g *Game) Update(t time.Duration) {
 		}
 	}
 
-	for door, doorTransition := range g.doorTransition {
+	doors := make([]*Door, 0, len(g.doorTransition))
+	for door, _ := range g.doorTransition {
+		doors = append(doors, door)
+	}
+	sort.Slice(doors, func(i, j int) bool { return doors[i].id < doors[j].id })
+
+	for _, door := range doors {
+		doorTransition := g.doorTransition[door]
 		doorTransition.Update(t)
 
 		if doorTransition.IsFinished() {
@@ -1537,7 +1303,14 @@ func (g *Game) Update(t time.Duration) {
 
 	}
 
-	for boulder, boulderTransition := range g.boulderTransition {
+	boulders := make([]*Boulder, 0, len(g.boulderTransition))
+	for boulder, _ := range g.boulderTransition {
+		boulders = append(boulders, boulder)
+	}
+	sort.Slice(boulders, func(i, j int) bool { return boulders[i].id < boulders[j].id })
+
+	for _, boulder := range boulders {
+		boulderTransition := g.boulderTransition[boulder]
 		boulderTransition.Update(t)
 
 		if boulderTransition.IsFinished() {
@@ -1551,7 +1324,11 @@ func (g *Game) Update(t time.Duration) {
 		}
 	}
 
-	for player, actionTransition := range g.playerActionTransition {
+	for _, player := range players {
+		actionTransition, ok := g.playerActionTransition[player]
+		if !ok {
+			continue
+		}
 		actionTransition.Update(t)
 
 		if actionTransition.IsFinished() {
@@ -1560,7 +1337,11 @@ func (g *Game) Update(t time.Duration) {
 		}
 	}
 
-	for _, visDelay := range g.visDelay {
+	for _, player := range players {
+		visDelay, ok := g.visDelay[player]
+		if !ok {
+			continue
+		}
 		visDelay.Update(t)
 		if visDelay.IsFinished() {
 			visDelay.UpdateGameState(g)
@@ -1568,7 +1349,11 @@ func (g *Game) Update(t time.Duration) {
 		}
 	}
 
-	for _, visTrans := range g.playerVisTransition {
+	for _, player := range players {
+		visTrans, ok := g.playerVisTransition[player]
+		if !ok {
+			continue
+		}
 		visTrans.Update(t)
 		if visTrans.IsFinished() {
 			visTrans.UpdateGameState(g)
@@ -1578,41 +1363,39 @@ func (g *Game) Update(t time.Duration) {
 	}
 }
 
-func (g *Game) PosEmptyInFuture(pos MapPosition) bool {
-	canBeEmpty := false
-	for _, playerMoveTrans := range g.playerMoveTransition {
-		if playerMoveTrans.OriginPos() == pos {
-			canBeEmpty = true
-		}
-	}
-
-	for _, boulderTransition := range g.boulderTransition {
-		if boulderTransition.OriginPos() == pos {
-			canBeEmpty = true
-		}
-	}
+func (g *Game) LevelId() string {
+	return GlobalConfig.levelId
+}
 
-	if canBeEmpty && !g.PosOccupiedInFuture(pos) {
-		return true
-	} else {
-		return false
-	}
+// Completed tells whether both players have reached the goal room.
+func (g *Game) Completed() bool {
+	return g.completed
 }
 
-func (g *Game) PosOccupiedInFuture(pos MapPosition) bool {
-	for _, playerMoveTrans := range g.playerMoveTransition {
-		if playerMoveTrans.TargetPos() == pos {
-			return true
-		}
+// checkCompleted completes the level once both players stand in the goal
+// room, it stays completed until the state is restored or rewound.
+func (g *Game) checkCompleted() {
+	room := g.roomById(GlobalConfig.goalRoom)
+	if g.completed || room == nil || len(g.players) < 2 {
+		return
 	}
-
-	for _, boulderTransition := range g.boulderTransition {
-		if boulderTransition.TargetPos() == pos {
-			return true
+	for _, player := range g.players {
+		if !room.Contains(g.playerState[player].mapPos) {
+			return
 		}
 	}
+	g.completed = true
+	g.event(EventLevelCompleted, int(room.id), true, Observer)
+}
 
-	return false
+// WalkTime is how long a move takes.
+func (g *Game) WalkTime() time.Duration {
+	return GlobalConfig.walkTime
+}
+
+// ActionTime is how long pulling a lever or pushing a boulder takes.
+func (g *Game) ActionTime() time.Duration {
+	return GlobalConfig.actionTime
 }
 
 func (g *Game) Width() int {
@@ -1699,6 +1482,14 @@ type Game struct {
 	triggerTransition map[*Trigger]*TriggerTransition
 	doorTransition    map[*Door]*DoorTransition
 
+	tick      Tick
+	scheduled []TickAction
+	intents   []*intent     // moves and pushes of the current tick, see resolve.go
+	pending   time.Duration // wall clock time not yet simulated, less than a tick
+	hashes    map[Tick]uint64
+	events    []GameEvent // nil unless recorded
+	completed bool        // both players reached the goal room
+
 	// spriteCarBG   *Sprite
 	// spriteWaiting *Sprite
 
@@ -1709,12 +1500,7 @@ type Game struct {
 }
 
 func (g *Game) IsEmpty(pos MapPosition) bool {
-	if !(g.IsDoor(pos) || g.IsBoulder(pos) || g.IsWall(pos) || g.IsPlayer(pos)) {
-		log.Println("Is states", g.IsDoor(pos), g.IsBoulder(pos), g.IsWall(pos), g.IsPlayer(pos))
-		return true
-	}
-	log.Println("Is states", g.IsDoor(pos), g.IsBoulder(pos), g.IsWall(pos), g.IsPlayer(pos))
-	return false
+	return !(g.IsDoor(pos) || g.IsBoulder(pos) || g.IsWall(pos) || g.IsPlayer(pos))
 }
 
 func (g *Game) IsPlayer(pos MapPosition) bool {
@@ -1862,8 +1648,7 @@ func (g *Game) NewPlayer(id int) Player {
 
 	g.players = append(g.players, player)
 
-	log.Println(id)
-	log.Println(GlobalConfig)
+	gameLog.Debug("New player", "player", player)
 	startPos := GlobalConfig.playerStartPos[id]
 
 	g.playerState[player] = NewPlayerState(startPos,
@@ -2022,8 +1807,32 @@ func (g *Game) BoulderPos(wantedBoulder *Boulder) (MapPosition, error) {
 }
 
 func NewGame() (*Game, error) {
-	width, height := GlobalConfig.mapWidth, GlobalConfig.mapHeight
-	r := &Game{
+	if GlobalConfig == nil {
+		return nil, errors.New("No level loaded")
+	}
+	if GlobalConfig.outline {
+		return nil, errors.New("The level is hidden")
+	}
+	r := newGame(GlobalConfig.mapWidth, GlobalConfig.mapHeight)
+	BuildGame(r)
+
+	// if r.music = mixer.LoadMUS("data/music.ogg"); r.music == nil {
+	// 	return nil, errors.New(sdl.GetError())
+	// }
+
+	// if r.font = ttf.OpenFont("data/font.otf", 32); r.font == nil {
+	// return nil, errors.New(sdl.GetError())
+	// }
+
+	// textWaiting := ttf.RenderUTF8_Blended(r.font, "Please start")
+	// r.spriteWaiting = NewSpriteFromSurface(textWaiting)
+
+	return r, nil
+}
+
+// newGame is an empty map without anything on it.
+func newGame(width, height int) *Game {
+	return &Game{
 		players:     make([]Player, 0, 2),
 		gameMap:     NewMap(width, height),
 		playerState: make(map[Player]*PlayerState, 2),
@@ -2046,24 +1855,13 @@ func NewGame() (*Game, error) {
 		triggerTransition: make(map[*Trigger]*TriggerTransition),
 		doorTransition:    make(map[*Door]*DoorTransition),
 
+		tick:      0,
+		scheduled: make([]TickAction, 0),
+		pending:   0,
+
 		running: false,
 		music:   nil,
 	}
-
-	BuildGame(r)
-
-	// if r.music = mixer.LoadMUS("data/music.ogg"); r.music == nil {
-	// 	return nil, errors.New(sdl.GetError())
-	// }
-
-	// if r.font = ttf.OpenFont("data/font.otf", 32); r.font == nil {
-	// return nil, errors.New(sdl.GetError())
-	// }
-
-	// textWaiting := ttf.RenderUTF8_Blended(r.font, "Please start")
-	// r.spriteWaiting = NewSpriteFromSurface(textWaiting)
-
-	return r, nil
 }
 
 func (r *Game) Join(player *Player) {
@@ -0,0 +1,221 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/banthar/Go-SDL/sdl"
+	"sort"
+	"strconv"
+)
+
+// The gamepad's stick and hat press the direction keys, so holding them walks
+// and tapping them looks around just like the keyboard. Its buttons are bound
+// in the bindings file, e.g.
+//
+//	"Gamepad": {"Buttons": {"action": [0, 2], "visibility": [1]}, "Deadzone": 0.3}
+
+const maxAxis = 32767
+
+// PadMap tells which gamepad buttons stand for which game key and how the
+// stick is read.
+type PadMap struct {
+	Buttons  ButtonMap
+	AxisX    uint8
+	AxisY    uint8
+	Deadzone float64 // the part of the stick's range around the center that is ignored
+}
+
+// ButtonMap tells which gamepad button stands for which game key.
+type ButtonMap map[uint8]Key
+
+func DefaultPadMap() PadMap {
+	return PadMap{
+		Buttons:  ButtonMap{0: KeySpace, 1: KeyEnter},
+		AxisX:    0,
+		AxisY:    1,
+		Deadzone: 0.35,
+	}
+}
+
+func (pm PadMap) Valid() error {
+	if pm.Deadzone < 0 || pm.Deadzone >= 1 {
+		return errors.New("Deadzone must be at least 0 and less than 1")
+	}
+	if pm.AxisX == pm.AxisY {
+		return errors.New("The stick needs two different axes")
+	}
+	return nil
+}
+
+func (bm ButtonMap) MarshalJSON() ([]byte, error) {
+	buttons := make(map[string][]int)
+	for button, key := range bm {
+		buttons[key.String()] = append(buttons[key.String()], int(button))
+	}
+	for _, list := range buttons {
+		sort.Ints(list)
+	}
+	return json.Marshal(buttons)
+}
+
+// UnmarshalJSON replaces the buttons of the game keys in data, the others
+// keep theirs.
+func (bm *ButtonMap) UnmarshalJSON(data []byte) error {
+	var buttons map[string][]int
+	if err := json.Unmarshal(data, &buttons); err != nil {
+		return err
+	}
+
+	for gameKey, list := range buttons {
+		if _, err := ParseGameKey(gameKey); err != nil {
+			return err
+		}
+		for _, button := range list {
+			if button < 0 || button > 255 {
+				return errors.New("Unknown gamepad button " + strconv.Itoa(button))
+			}
+		}
+	}
+
+	if *bm == nil {
+		*bm = make(ButtonMap)
+	}
+	for _, key := range GameKeys() {
+		list, ok := buttons[key.String()]
+		if !ok {
+			continue
+		}
+		for button, k := range *bm {
+			if k == key {
+				delete(*bm, button)
+			}
+		}
+		for _, button := range list {
+			(*bm)[uint8(button)] = key
+		}
+	}
+	return nil
+}
+
+// padState is what the gamepad holds down.
+type padState struct {
+	x, y    int16
+	stick   Key // the direction the stick points in, -1 if none
+	hat     Key
+	buttons map[uint8]bool
+}
+
+func newPadState() *padState {
+	return &padState{stick: -1, hat: -1, buttons: make(map[uint8]bool)}
+}
+
+func (ps *padState) holds(padMap PadMap, key Key) bool {
+	if ps.stick == key || ps.hat == key {
+		return true
+	}
+	for button, _ := range ps.buttons {
+		if k, ok := padMap.Buttons[button]; ok && k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// stickKey is the direction key of the axis the stick is pushed further
+// along, so a slightly diagonal stick does not walk both ways.
+func stickKey(x, y int16, deadzone float64) Key {
+	ax, ay := abs(int(x)), abs(int(y))
+	if float64(ax) <= deadzone*maxAxis && float64(ay) <= deadzone*maxAxis {
+		return -1
+	}
+	if ax >= ay {
+		if x < 0 {
+			return KeyA
+		}
+		return KeyD
+	}
+	if y < 0 {
+		return KeyW
+	}
+	return KeyS
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func hatKey(value uint8) Key {
+	switch {
+	case value&sdl.HAT_LEFT != 0:
+		return KeyA
+	case value&sdl.HAT_RIGHT != 0:
+		return KeyD
+	case value&sdl.HAT_UP != 0:
+		return KeyW
+	case value&sdl.HAT_DOWN != 0:
+		return KeyS
+	}
+	return -1
+}
+
+func (is *InputState) setPadKey(current *Key, key Key) {
+	if *current == key {
+		return
+	}
+	old := *current
+	*current = key
+	if old >= 0 {
+		is.release(old)
+	}
+	if key >= 0 {
+		is.press(key)
+	}
+}
+
+func (is *InputState) HandleJoyAxis(e *sdl.JoyAxisEvent) {
+	switch e.Axis {
+	case is.padMap.AxisX:
+		is.pad.x = e.Value
+	case is.padMap.AxisY:
+		is.pad.y = e.Value
+	default:
+		return
+	}
+	is.setPadKey(&is.pad.stick, stickKey(is.pad.x, is.pad.y, is.padMap.Deadzone))
+}
+
+// HandleJoyHat uses the hat like the stick, the horizontal direction wins
+// if it points diagonally.
+func (is *InputState) HandleJoyHat(e *sdl.JoyHatEvent) {
+	is.setPadKey(&is.pad.hat, hatKey(e.Value))
+}
+
+func (is *InputState) HandleJoyButton(e *sdl.JoyButtonEvent) {
+	key, ok := is.padMap.Buttons[e.Button]
+	if !ok {
+		return
+	}
+
+	if e.Type == sdl.JOYBUTTONDOWN {
+		is.pad.buttons[e.Button] = true
+		is.press(key)
+	} else if e.Type == sdl.JOYBUTTONUP {
+		delete(is.pad.buttons, e.Button)
+		is.release(key)
+	}
+}
+
+// SetPadMap changes the gamepad bindings, everything on the gamepad counts as
+// released.
+func (is *InputState) SetPadMap(padMap PadMap) {
+	pad, oldMap := is.pad, is.padMap
+	is.padMap, is.pad = padMap, newPadState()
+	for _, key := range GameKeys() {
+		if pad.holds(oldMap, key) {
+			is.release(key)
+		}
+	}
+}
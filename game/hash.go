@@ -0,0 +1,57 @@
+package game
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+)
+
+// Every HashInterval ticks a game can remember its state hash, peers compare
+// their hashes for these ticks.
+const HashInterval Tick = 50
+
+const maxRecordedHashes = 16
+
+// StateHash is a hash of everything the players can see and of the running
+// transitions at the current tick. Peers that agree on it agree on the game.
+// Actions scheduled for this or later ticks are not part of the state yet.
+func (g *Game) StateHash() uint64 {
+	s := g.Snapshot()
+	s.Scheduled = nil
+	s.Intents = nil
+
+	// the order players joined in differs between the peers
+	sort.Slice(s.Players, func(i, j int) bool { return s.Players[i].Player < s.Players[j].Player })
+
+	// gob numbers its types per process, json gives the same bytes for the
+	// same state everywhere
+	data, err := json.Marshal(s)
+	if err != nil {
+		return 0
+	}
+
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64()
+}
+
+// RecordHashes makes the game remember the state hash of the last few
+// checkpoint ticks. The hash is taken right after the tick is reached, before
+// any action scheduled for it.
+func (g *Game) RecordHashes() {
+	g.hashes = make(map[Tick]uint64)
+}
+
+func (g *Game) HashAt(tick Tick) (uint64, bool) {
+	hash, ok := g.hashes[tick]
+	return hash, ok
+}
+
+func (g *Game) recordHash() {
+	if g.hashes == nil || g.tick%HashInterval != 0 {
+		return
+	}
+
+	g.hashes[g.tick] = g.StateHash()
+	delete(g.hashes, g.tick-maxRecordedHashes*HashInterval)
+}
@@ -0,0 +1,64 @@
+package game
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"unicode"
+)
+
+const maxNickLength = 16
+const playerIdLength = 32
+
+func RoleName(player Player) string {
+	switch player {
+	case Human:
+		return "human"
+	case Ghost:
+		return "ghost"
+	case Observer:
+		return "spectator"
+	}
+	return "unknown"
+}
+
+// CleanNick trims the nickname and checks that it can be shown to other
+// players.
+func CleanNick(nick string) (string, error) {
+	nick = strings.TrimSpace(nick)
+
+	if len([]rune(nick)) > maxNickLength {
+		return "", errors.New("Nickname too long")
+	}
+
+	for _, r := range nick {
+		if !unicode.IsPrint(r) {
+			return "", errors.New("Nickname contains invalid characters")
+		}
+	}
+
+	return nick, nil
+}
+
+func NewPlayerId() string {
+	b := make([]byte, playerIdLength/2)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// ValidPlayerId accepts the empty id, players are not required to have one.
+func ValidPlayerId(id string) bool {
+	if id == "" {
+		return true
+	}
+
+	if len(id) != playerIdLength {
+		return false
+	}
+
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
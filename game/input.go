@@ -2,7 +2,7 @@ package game
 
 import (
 	"github.com/banthar/Go-SDL/sdl"
-	"log"
+	"strconv"
 	"time"
 )
 
@@ -17,11 +17,52 @@ const (
 	KeyEnter
 )
 
+// KeyMap tells which keyboard key stands for which game key.
+type KeyMap map[uint32]Key
+
+func DefaultKeyMap() KeyMap {
+	return KeyMap{
+		sdl.K_a:      KeyA,
+		sdl.K_w:      KeyW,
+		sdl.K_d:      KeyD,
+		sdl.K_s:      KeyS,
+		sdl.K_SPACE:  KeySpace,
+		sdl.K_RETURN: KeyEnter,
+	}
+}
+
+// LeftKeyMap and RightKeyMap let two players share a keyboard.
+func LeftKeyMap() KeyMap {
+	return KeyMap{
+		sdl.K_a:      KeyA,
+		sdl.K_w:      KeyW,
+		sdl.K_d:      KeyD,
+		sdl.K_s:      KeyS,
+		sdl.K_SPACE:  KeySpace,
+		sdl.K_LSHIFT: KeyEnter,
+	}
+}
+
+func RightKeyMap() KeyMap {
+	return KeyMap{
+		sdl.K_LEFT:   KeyA,
+		sdl.K_UP:     KeyW,
+		sdl.K_RIGHT:  KeyD,
+		sdl.K_DOWN:   KeyS,
+		sdl.K_RCTRL:  KeySpace,
+		sdl.K_RSHIFT: KeyEnter,
+	}
+}
+
 type InputState struct {
 	keysDown map[Key]bool
+	held     map[uint32]bool // keyboard keys that are down
+	pad      *padState
 	actions  []Action
 	game     *Game
 	player   Player
+	keyMap   KeyMap
+	padMap   PadMap
 }
 
 type ActionType int
@@ -41,12 +82,42 @@ const (
 	ActionPlayerReady
 )
 
+var actionNames = []string{
+	"none",
+	"look-north", "look-west", "look-south", "look-east",
+	"move-north", "move-west", "move-south", "move-east",
+	"action", "toggle-visibility", "ready",
+}
+
+func (a ActionType) Valid() bool {
+	return a >= ActionNoAction && a <= ActionPlayerReady
+}
+
+func (a ActionType) String() string {
+	if a < 0 || int(a) >= len(actionNames) {
+		return "action" + strconv.Itoa(int(a))
+	}
+	return actionNames[a]
+}
+
 func NewInputState(game *Game, player Player) *InputState {
+	return NewInputStateWithKeys(game, player, DefaultKeyMap())
+}
+
+func NewInputStateWithKeys(game *Game, player Player, keyMap KeyMap) *InputState {
+	return NewInputStateWithBindings(game, player, keyMap, DefaultPadMap())
+}
+
+func NewInputStateWithBindings(game *Game, player Player, keyMap KeyMap, padMap PadMap) *InputState {
 	return &InputState{
 		keysDown: make(map[Key]bool, 6),
+		held:     make(map[uint32]bool),
+		pad:      newPadState(),
 		actions:  make([]Action, 0, 10),
 		game:     game,
 		player:   player,
+		keyMap:   keyMap,
+		padMap:   padMap,
 	}
 }
 
@@ -175,7 +246,6 @@ func (kla *KeyLongAction) Update(inputS *InputState, dt time.Duration) (ActionTy
 
 		target := inputS.game.playerState[inputS.player].mapPos.Neighbor(dir)
 		if inputS.game.IsBoulder(target) && inputS.game.Boulders()[target].IsActive() {
-			log.Println("active boulder here")
 			return ActionAction, true, next
 		}
 
@@ -222,51 +292,60 @@ func (ea *EnterAction) Update(inputS *InputState, dt time.Duration) (ActionType,
 	return ActionNoAction, true, ea
 }
 
+// SetKeyMap changes the key bindings, every keyboard key counts as released.
+func (is *InputState) SetKeyMap(keyMap KeyMap) {
+	is.keyMap = keyMap
+	is.held = make(map[uint32]bool)
+	for _, key := range GameKeys() {
+		is.release(key)
+	}
+}
+
+// press puts the game key down and starts its action, a key that is down
+// already keeps the action it has.
+func (is *InputState) press(key Key) {
+	if is.KeyDown(key) {
+		return
+	}
+	is.SetKeyDown(key)
+	switch key {
+	case KeyA, KeyW, KeyD, KeyS:
+		is.AddAction(NewKeyShortAction(key))
+	case KeySpace:
+		is.AddAction(NewSpaceAction())
+	case KeyEnter:
+		is.AddAction(NewEnterAction())
+	}
+}
+
+// release puts the game key up unless a keyboard key or the gamepad still
+// holds it.
+func (is *InputState) release(key Key) {
+	for sym, _ := range is.held {
+		if is.keyMap[sym] == key {
+			return
+		}
+	}
+	if is.pad.holds(is.padMap, key) {
+		return
+	}
+	is.SetKeyUp(key)
+}
+
+// HandleEvent turns keyboard keys into game keys. A game key with several
+// keyboard keys is down while any of them is.
 func (is *InputState) HandleEvent(e *sdl.KeyboardEvent) {
+	key, ok := is.keyMap[e.Keysym.Sym]
+	if !ok {
+		return
+	}
+
 	if e.Type == sdl.KEYDOWN {
-		switch e.Keysym.Sym {
-		case sdl.K_a:
-			// if !(is.KeyDown(KeyS) || is.KeyDown(KeyW) || is.KeyDown(KeyD)) {
-			is.SetKeyDown(KeyA)
-			is.AddAction(NewKeyShortAction(KeyA))
-			// }
-		case sdl.K_w:
-			// if !(is.KeyDown(KeyA) || is.KeyDown(KeyS) || is.KeyDown(KeyD)) {
-			is.SetKeyDown(KeyW)
-			is.AddAction(NewKeyShortAction(KeyW))
-			// }
-		case sdl.K_d:
-			// if !(is.KeyDown(KeyA) || is.KeyDown(KeyW) || is.KeyDown(KeyS)) {
-			is.SetKeyDown(KeyD)
-			is.AddAction(NewKeyShortAction(KeyD))
-			// }
-		case sdl.K_s:
-			// if !(is.KeyDown(KeyA) || is.KeyDown(KeyW) || is.KeyDown(KeyD)) {
-			is.SetKeyDown(KeyS)
-			is.AddAction(NewKeyShortAction(KeyS))
-			// }
-		case sdl.K_SPACE:
-			is.SetKeyDown(KeySpace)
-			is.AddAction(NewSpaceAction())
-		case sdl.K_RETURN:
-			is.SetKeyDown(KeyEnter)
-			is.AddAction(NewEnterAction())
-		}
+		is.held[e.Keysym.Sym] = true
+		is.press(key)
 	} else if e.Type == sdl.KEYUP {
-		switch e.Keysym.Sym {
-		case sdl.K_a:
-			is.SetKeyUp(KeyA)
-		case sdl.K_w:
-			is.SetKeyUp(KeyW)
-		case sdl.K_d:
-			is.SetKeyUp(KeyD)
-		case sdl.K_s:
-			is.SetKeyUp(KeyS)
-		case sdl.K_SPACE:
-			is.SetKeyUp(KeySpace)
-		case sdl.K_RETURN:
-			is.SetKeyUp(KeyEnter)
-		}
+		delete(is.held, e.Keysym.Sym)
+		is.release(key)
 	}
 }
 
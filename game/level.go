@@ -0,0 +1,231 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+)
+
+// A Level is everything about a map that does not change while playing. The
+// server reads it from a file, clients get it when they join. Targets that
+// are not set are -1, players are 0 for the human, 1 for the ghost and -1
+// for both.
+type Level struct {
+	Id         string // changes whenever the layout changes, saves refer to it
+	Width      int
+	Height     int
+	WalkTime   string // e.g. "200ms"
+	RollTime   string
+	ActionTime string
+	GoalRoom   RoomID       // the level is completed once both players are in it
+	Starts     []LevelStart // one per player
+	Walls      []string     // one row per line, '#' is a wall; none for an outline
+	Triggers   []LevelTrigger
+	Plates     []LevelPlate
+	Doors      []LevelDoor
+	Boulders   []LevelBoulder
+	BannWalls  []LevelBannWall
+	Rooms      []LevelRoom
+}
+
+type LevelStart struct {
+	Pos     SnapshotPos
+	LooksIn Direction
+}
+
+type LevelTrigger struct {
+	ID         TriggerID
+	Pos        SnapshotPos
+	Dir        Direction // the side of the cell the lever is on
+	Door       DoorID
+	BannWall   BannWallID
+	Boulder    BoulderID // dropped when the lever is pulled
+	CanTrigger Player
+	CanSee     Player
+}
+
+type LevelPlate struct {
+	ID       PlateID
+	Pos      SnapshotPos
+	Door     DoorID
+	BannWall BannWallID
+}
+
+type LevelDoor struct {
+	ID   DoorID
+	Pos  SnapshotPos
+	Room RoomID // shown once the door is opened
+}
+
+type LevelBoulder struct {
+	ID     BoulderID
+	Pos    SnapshotPos
+	Active bool // false until a lever drops it
+}
+
+type LevelBannWall struct {
+	ID   BannWallID
+	Pos  SnapshotPos
+	Type int
+}
+
+// A LevelRoom is made of rectangles, both corners are part of it.
+type LevelRoom struct {
+	ID      RoomID
+	Rects   []LevelRect
+	Visible bool // seen from the start
+}
+
+type LevelRect struct {
+	From SnapshotPos
+	To   SnapshotPos
+}
+
+func ReadLevelFile(path string) (*Level, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var level Level
+	if err := json.NewDecoder(file).Decode(&level); err != nil {
+		return nil, err
+	}
+	if err := level.check(); err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+	return &level, nil
+}
+
+// Outline is what a client may know about the level while the server hides
+// the map: its size and timing, but nothing on it.
+func (l *Level) Outline() *Level {
+	return &Level{
+		Id:         l.Id,
+		Width:      l.Width,
+		Height:     l.Height,
+		WalkTime:   l.WalkTime,
+		RollTime:   l.RollTime,
+		ActionTime: l.ActionTime,
+		GoalRoom:   -1,
+	}
+}
+
+// IsOutline tells whether the level has no map, a game cannot be built from
+// it.
+func (l *Level) IsOutline() bool {
+	return len(l.Walls) == 0
+}
+
+func (l *Level) check() error {
+	if l.Id == "" {
+		return errors.New("Level has no id")
+	}
+	if l.Width <= 0 || l.Height <= 0 {
+		return errors.New("Invalid level size")
+	}
+	for _, d := range []string{l.WalkTime, l.RollTime, l.ActionTime} {
+		if t, err := time.ParseDuration(d); err != nil || t <= 0 {
+			return errors.New("Invalid level time " + d)
+		}
+	}
+	if l.IsOutline() {
+		return nil
+	}
+
+	if len(l.Walls) != l.Height {
+		return errors.New("Level needs " + strconv.Itoa(l.Height) + " rows of walls")
+	}
+	for _, row := range l.Walls {
+		if len(row) != l.Width {
+			return errors.New("Wall row " + strconv.Quote(row) + " is not " + strconv.Itoa(l.Width) + " cells wide")
+		}
+	}
+
+	if len(l.Starts) != 2 {
+		return errors.New("Level needs a start for both players")
+	}
+	for _, start := range l.Starts {
+		if !l.inside(start.Pos) || !start.LooksIn.Valid() {
+			return errors.New("Invalid player start")
+		}
+	}
+
+	doors := make(map[DoorID]bool)
+	rooms := make(map[RoomID]bool)
+	boulders := make(map[BoulderID]bool)
+	bannWalls := make(map[BannWallID]bool)
+	for _, room := range l.Rooms {
+		for _, rect := range room.Rects {
+			if !l.inside(rect.From) || !l.inside(rect.To) {
+				return errors.New("Room " + strconv.Itoa(int(room.ID)) + " is outside of the map")
+			}
+		}
+		rooms[room.ID] = true
+	}
+	for _, door := range l.Doors {
+		if !l.inside(door.Pos) || (door.Room >= 0 && !rooms[door.Room]) {
+			return errors.New("Invalid door " + strconv.Itoa(int(door.ID)))
+		}
+		doors[door.ID] = true
+	}
+	for _, boulder := range l.Boulders {
+		if !l.inside(boulder.Pos) {
+			return errors.New("Invalid boulder " + strconv.Itoa(int(boulder.ID)))
+		}
+		boulders[boulder.ID] = true
+	}
+	for _, bannWall := range l.BannWalls {
+		if !l.inside(bannWall.Pos) {
+			return errors.New("Invalid bann wall " + strconv.Itoa(int(bannWall.ID)))
+		}
+		bannWalls[bannWall.ID] = true
+	}
+	for _, trigger := range l.Triggers {
+		if !l.inside(trigger.Pos) || !trigger.Dir.Valid() ||
+			(trigger.Door > 0 && !doors[trigger.Door]) ||
+			(trigger.BannWall > 0 && !bannWalls[trigger.BannWall]) ||
+			(trigger.Boulder >= 0 && !boulders[trigger.Boulder]) {
+			return errors.New("Invalid lever " + strconv.Itoa(int(trigger.ID)))
+		}
+	}
+	for _, plate := range l.Plates {
+		if !l.inside(plate.Pos) ||
+			(plate.Door > 0 && !doors[plate.Door]) ||
+			(plate.BannWall > 0 && !bannWalls[plate.BannWall]) {
+			return errors.New("Invalid plate " + strconv.Itoa(int(plate.ID)))
+		}
+	}
+	if !rooms[l.GoalRoom] {
+		return errors.New("Level has no goal room")
+	}
+
+	return nil
+}
+
+func (l *Level) inside(pos SnapshotPos) bool {
+	return pos.X >= 0 && pos.X < l.Width && pos.Y >= 0 && pos.Y < l.Height
+}
+
+// LoadLevel makes level the one new games are built from.
+func LoadLevel(level *Level) error {
+	if level == nil {
+		return errors.New("No level")
+	}
+	if err := level.check(); err != nil {
+		return err
+	}
+	GlobalConfig = newMapConfig(level)
+	return nil
+}
+
+// CurrentLevel is the level loaded last, nil if there is none.
+func CurrentLevel() *Level {
+	if GlobalConfig == nil {
+		return nil
+	}
+	return GlobalConfig.level
+}
@@ -0,0 +1,35 @@
+package game
+
+import (
+	"os"
+	"testing"
+)
+
+// The tests play the level the server uses by default.
+func TestMain(m *testing.M) {
+	level, err := ReadLevelFile("../levels/gamejam-1.json")
+	if err == nil {
+		err = LoadLevel(level)
+	}
+	if err != nil {
+		gameLog.Error("Failed to load level", "err", err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+func TestLevelOutline(t *testing.T) {
+	level := CurrentLevel()
+	outline := level.Outline()
+	if !outline.IsOutline() || len(outline.Triggers) != 0 || len(outline.Starts) != 0 {
+		t.Fatal("Outline holds the map")
+	}
+
+	defer LoadLevel(level)
+	if err := LoadLevel(outline); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewGame(); err == nil {
+		t.Fatal("Game built from an outline")
+	}
+}
@@ -0,0 +1,203 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Components a log line can belong to, each one can be given its own level.
+const (
+	LogGame    = "game"    // the simulation and its actions
+	LogNet     = "net"     // connections and the protocol
+	LogSession = "session" // joining, saves, replays and votes
+	LogRender  = "render"
+	LogAudio   = "audio"
+)
+
+var (
+	gameLog  = NewLogger(LogGame)
+	netLog   = NewLogger(LogNet)
+	audioLog = NewLogger(LogAudio)
+)
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level LogLevel) String() string {
+	if level < LevelDebug || level > LevelError {
+		return "level" + strconv.Itoa(int(level))
+	}
+	return levelNames[level]
+}
+
+func ParseLogLevel(name string) (LogLevel, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return LogLevel(i), nil
+		}
+	}
+	return LevelInfo, errors.New("Unknown log level " + name)
+}
+
+var logOutput = struct {
+	lock       sync.Mutex
+	writer     io.Writer
+	level      LogLevel
+	components map[string]LogLevel // overrides level
+}{
+	writer:     os.Stderr,
+	level:      LevelInfo,
+	components: make(map[string]LogLevel),
+}
+
+// SetLogLevels reads a level for everything, optionally followed by levels
+// for single components, e.g. "warn" or "warn,net=debug,game=info".
+func SetLogLevels(spec string) error {
+	level := LevelInfo
+	components := make(map[string]LogLevel)
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if i := strings.Index(part, "="); i >= 0 {
+			componentLevel, err := ParseLogLevel(part[i+1:])
+			if err != nil {
+				return err
+			}
+			components[strings.TrimSpace(part[:i])] = componentLevel
+			continue
+		}
+
+		var err error
+		if level, err = ParseLogLevel(part); err != nil {
+			return err
+		}
+	}
+
+	logOutput.lock.Lock()
+	defer logOutput.lock.Unlock()
+	logOutput.level = level
+	logOutput.components = components
+	return nil
+}
+
+func SetLogOutput(w io.Writer) {
+	logOutput.lock.Lock()
+	defer logOutput.lock.Unlock()
+	logOutput.writer = w
+}
+
+// A Logger writes lines of a component in logfmt, with the fields it was
+// given, e.g. the session and the player.
+type Logger struct {
+	component string
+	fields    []interface{} // key, value, key, value, ...
+}
+
+func NewLogger(component string) *Logger {
+	return &Logger{component: component}
+}
+
+// With returns a logger that adds the key value pairs to every line.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	return &Logger{component: l.component, fields: fields}
+}
+
+func (l *Logger) Enabled(level LogLevel) bool {
+	logOutput.lock.Lock()
+	defer logOutput.lock.Unlock()
+
+	if componentLevel, ok := logOutput.components[l.component]; ok {
+		return level >= componentLevel
+	}
+	return level >= logOutput.level
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.write(LevelDebug, msg, keyvals)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.write(LevelInfo, msg, keyvals)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.write(LevelWarn, msg, keyvals)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.write(LevelError, msg, keyvals)
+}
+
+// Fatal logs an error whatever the level and exits.
+func (l *Logger) Fatal(msg string, keyvals ...interface{}) {
+	l.output(LevelError, msg, keyvals)
+	os.Exit(1)
+}
+
+func logValue(v interface{}) string {
+	var s string
+	switch value := v.(type) {
+	case string:
+		s = value
+	case error:
+		s = value.Error()
+	case time.Duration:
+		s = value.String()
+	default:
+		s = fmt.Sprint(value)
+	}
+
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func (l *Logger) write(level LogLevel, msg string, keyvals []interface{}) {
+	if l.Enabled(level) {
+		l.output(level, msg, keyvals)
+	}
+}
+
+func (l *Logger) output(level LogLevel, msg string, keyvals []interface{}) {
+	var line strings.Builder
+	line.WriteString("time=" + time.Now().Format("2006-01-02T15:04:05.000"))
+	line.WriteString(" level=" + level.String())
+	line.WriteString(" component=" + l.component)
+	line.WriteString(" msg=" + logValue(msg))
+
+	fields := append(append([]interface{}{}, l.fields...), keyvals...)
+	for i := 0; i < len(fields); i += 2 {
+		if i+1 < len(fields) {
+			line.WriteString(" " + fmt.Sprint(fields[i]) + "=" + logValue(fields[i+1]))
+		} else {
+			line.WriteString(" extra=" + logValue(fields[i]))
+		}
+	}
+	line.WriteString("\n")
+
+	logOutput.lock.Lock()
+	defer logOutput.lock.Unlock()
+	io.WriteString(logOutput.writer, line.String())
+}
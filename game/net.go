@@ -1,9 +1,10 @@
 package game
 
 import (
-// "bytes"
-// "encoding/gob"
-// "fmt"
+	// "bytes"
+	// "encoding/gob"
+	// "fmt"
+	"time"
 )
 
 // func (v ActionType) MarshalBinary() ([]byte, error) {
@@ -24,7 +25,86 @@ const (
 type ClientRequest int
 
 const (
-	ClientReqSendAction ClientRequest = iota
+	ClientReqSendAction ClientRequest = iota // followed by the count (at most 1) and the action, answered with a ServerResponse and the tick or a DenyReason
 	ClientReqUpdate
 	ClientReqGameState
+	ClientReqSnapshot
+	ClientReqPing
+	ClientReqPlayers
+	ClientReqMessage  // followed by a Message, answered with a ServerResponse
+	ClientReqMessages // answered with the []Message received since the last request
+	ClientReqHash     // followed by a StateHash, answered with a bool and a GameSnapshot if it is true
+	ClientReqSave     // answered with a ServerResponse and a DenyReason if it is denied
+	ClientReqVote     // followed by a VoteKind, answered with a ServerResponse
+	ClientReqView     // answered with a SessionStatus and a PlayerView, replaces updates if the map is hidden
 )
+
+func (r ClientRequest) Valid() bool {
+	return r >= ClientReqSendAction && r <= ClientReqView
+}
+
+type SessionStatus int
+
+const (
+	SessionRunning SessionStatus = iota
+	SessionPartnerDisconnected
+	SessionReset
+	SessionRewound // undo or restart, the client has to fetch a snapshot
+)
+
+// Both players have to vote for an undo or a restart before it happens.
+type VoteKind int
+
+const (
+	VoteUndo VoteKind = iota
+	VoteRestart
+)
+
+func (k VoteKind) Valid() bool {
+	return k == VoteUndo || k == VoteRestart
+}
+
+type JoinRequest struct {
+	Token     string
+	Spectator bool // watch the session without taking a player slot
+	Nick      string
+	PlayerId  string // optional, stays the same across sessions
+}
+
+type JoinResponse struct {
+	Accepted  bool
+	Reason    string // why the join was not accepted
+	Resumed   bool
+	Spectator bool
+	Player    Player
+	Token     string
+	Nick      string // nickname as accepted by the server
+	Fog       bool   // the map is hidden, the client asks for views instead of updates
+	Level     *Level // only its outline if the map is hidden
+}
+
+// PlayerInfo describes a player of the session to the other clients.
+type PlayerInfo struct {
+	Player    Player
+	Nick      string
+	Connected bool
+}
+
+// Ping is sent by clients in regular intervals, the server answers with a
+// Pong carrying the same timestamp.
+type Ping struct {
+	Sent    int64         // client clock, unix nanoseconds
+	LastRTT time.Duration // round trip time of the previous ping
+}
+
+type Pong struct {
+	Sent int64
+}
+
+// StateHash is sent for the checkpoint tick the server asks for in the reply
+// to an update. A hash that does not match the server's makes the server send
+// a snapshot.
+type StateHash struct {
+	Tick Tick
+	Hash uint64
+}
@@ -0,0 +1,270 @@
+package game
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ReplayVersion is increased whenever old replays can no longer be read.
+const ReplayVersion = 2
+
+// A replay file holds one JSON value per line: the ReplayHeader, then a
+// ReplayEvent for everything that changed the game from the outside. The
+// game itself is deterministic, the events on their ticks are enough to
+// play the session again. The level comes along, players of the replay need
+// not have it.
+type ReplayHeader struct {
+	Version int
+	Level   *Level
+	Started time.Time
+	Players []SavedPlayer
+	Start   *GameSnapshot
+}
+
+// ReplayEvent is an action performed on Tick or, if Rewind is set, an undo
+// or restart that put the game into that state.
+type ReplayEvent struct {
+	Tick   Tick
+	Time   time.Time
+	Player Player
+	Action ActionType
+	Rewind *GameSnapshot `json:",omitempty"`
+}
+
+// ReplayWriter appends events to a replay file as they happen, a crash only
+// loses the event being written.
+type ReplayWriter struct {
+	file *os.File
+	enc  *json.Encoder
+}
+
+func CreateReplay(path string, g *Game, players []SavedPlayer) (*ReplayWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rw := &ReplayWriter{file: file, enc: json.NewEncoder(file)}
+	err = rw.enc.Encode(ReplayHeader{
+		Version: ReplayVersion,
+		Level:   GlobalConfig.level,
+		Started: time.Now(),
+		Players: players,
+		Start:   g.Snapshot(),
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return rw, nil
+}
+
+func (rw *ReplayWriter) Action(tick Tick, player Player, action ActionType) error {
+	return rw.enc.Encode(ReplayEvent{Tick: tick, Time: time.Now(), Player: player, Action: action})
+}
+
+// Rewind records the state the game was put into by an undo or restart.
+func (rw *ReplayWriter) Rewind(g *Game) error {
+	return rw.enc.Encode(ReplayEvent{Tick: g.Tick(), Time: time.Now(), Player: Observer, Rewind: g.Snapshot()})
+}
+
+func (rw *ReplayWriter) Close() error {
+	return rw.file.Close()
+}
+
+type Replay struct {
+	Header ReplayHeader
+	Events []ReplayEvent
+}
+
+// ReadReplay reads a whole replay. A replay that ends in a half written line
+// is cut off there, the server may have stopped while writing it.
+func ReadReplay(r io.Reader) (*Replay, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	var replay Replay
+	if err := dec.Decode(&replay.Header); err != nil {
+		return nil, err
+	}
+
+	if replay.Header.Version != ReplayVersion {
+		return nil, errors.New("Unsupported replay version")
+	}
+
+	if replay.Header.Level == nil || replay.Header.Level.IsOutline() {
+		return nil, errors.New("Replay has no level")
+	}
+	if err := replay.Header.Level.check(); err != nil {
+		return nil, err
+	}
+
+	if replay.Header.Start == nil {
+		return nil, errors.New("Replay has no start state")
+	}
+
+	for {
+		var event ReplayEvent
+		if err := dec.Decode(&event); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, err
+		}
+		replay.Events = append(replay.Events, event)
+	}
+
+	return &replay, nil
+}
+
+func ReadReplayFile(path string) (*Replay, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadReplay(file)
+}
+
+// ReplayPlayer plays a replay back. It can only simulate forward, seeking
+// backwards starts over from the beginning.
+type ReplayPlayer struct {
+	replay  *Replay
+	game    *Game
+	next    int // first event not yet handed to the game
+	speed   float64
+	paused  bool
+	pending time.Duration
+}
+
+func NewReplayPlayer(replay *Replay) (*ReplayPlayer, error) {
+	rp := &ReplayPlayer{replay: replay, speed: 1}
+	if err := rp.restart(); err != nil {
+		return nil, err
+	}
+	return rp, nil
+}
+
+func (rp *ReplayPlayer) restart() error {
+	g, err := NewGame()
+	if err != nil {
+		return err
+	}
+
+	if err := g.RestoreSnapshot(rp.replay.Header.Start); err != nil {
+		return err
+	}
+
+	rp.game = g
+	rp.next = 0
+	rp.pending = 0
+	return nil
+}
+
+func (rp *ReplayPlayer) Game() *Game {
+	return rp.game
+}
+
+func (rp *ReplayPlayer) Tick() Tick {
+	return rp.game.Tick()
+}
+
+func (rp *ReplayPlayer) StartTick() Tick {
+	return rp.replay.Header.Start.Tick
+}
+
+// EndTick is the tick of the last event, plus some time to see it play out.
+func (rp *ReplayPlayer) EndTick() Tick {
+	end := rp.StartTick()
+	if n := len(rp.replay.Events); n > 0 {
+		end = rp.replay.Events[n-1].Tick
+	}
+	return end + Tick(2*time.Second/TickDuration)
+}
+
+func (rp *ReplayPlayer) Speed() float64 {
+	return rp.speed
+}
+
+func (rp *ReplayPlayer) SetSpeed(speed float64) {
+	if speed > 0 {
+		rp.speed = speed
+	}
+}
+
+func (rp *ReplayPlayer) IsPaused() bool {
+	return rp.paused
+}
+
+func (rp *ReplayPlayer) SetPaused(paused bool) {
+	rp.paused = paused
+}
+
+// Seek jumps to tick, backwards by playing the replay again from the start.
+func (rp *ReplayPlayer) Seek(tick Tick) error {
+	if tick < rp.StartTick() {
+		tick = rp.StartTick()
+	}
+	if tick > rp.EndTick() {
+		tick = rp.EndTick()
+	}
+
+	if tick < rp.game.Tick() {
+		if err := rp.restart(); err != nil {
+			return err
+		}
+	}
+
+	rp.advance(tick)
+	return nil
+}
+
+// Update plays t times the speed further, unless paused or at the end.
+func (rp *ReplayPlayer) Update(t time.Duration) {
+	if rp.paused {
+		return
+	}
+
+	rp.pending += time.Duration(float64(t) * rp.speed)
+	ticks := Tick(rp.pending / TickDuration)
+	rp.pending -= time.Duration(ticks) * TickDuration
+
+	target := rp.game.Tick() + ticks
+	if target > rp.EndTick() {
+		target = rp.EndTick()
+		rp.paused = true
+	}
+	rp.advance(target)
+}
+
+// advance hands the game every event before tick, the same way a client
+// schedules the actions it gets from the server, and simulates up to tick.
+func (rp *ReplayPlayer) advance(tick Tick) {
+	for rp.next < len(rp.replay.Events) && rp.replay.Events[rp.next].Tick < tick {
+		event := rp.replay.Events[rp.next]
+		rp.next++
+
+		rp.game.StepTo(event.Tick)
+		var err error
+		if event.Rewind != nil {
+			err = rp.game.RestoreSnapshot(event.Rewind)
+		} else {
+			err = rp.game.ScheduleAction(event.Tick, event.Player, event.Action)
+		}
+		if err != nil {
+			gameLog.Warn("Failed to replay event", "tick", event.Tick, "err", err)
+		}
+	}
+
+	rp.game.StepTo(tick)
+}
@@ -0,0 +1,209 @@
+package game
+
+import (
+	"sort"
+)
+
+// Moves and pushes do not happen when they are asked for. They are collected
+// as intents and resolved together right before their tick is simulated, so
+// the order the actions of one tick arrive in does not matter:
+//
+//  1. A mover only goes where the level lets it: not through walls, closed
+//     doors or boulders it cannot pass, a boulder only where nothing is or an
+//     open door. A player or boulder in the way may still leave, see rule 3.
+//  2. A cell something is already moving into is taken.
+//  3. A cell with something in it is free if what is in it moves out, also
+//     in the same tick, and nothing else moves in.
+//  4. Two movers heading for the same cell both stay, and so do two movers
+//     swapping their cells.
+//  5. A mover that stays frees nothing, whoever needed it to leave stays as
+//     well. This is repeated until nothing changes.
+//
+// A ghost passes boulders, so it never collides with one. A move that stays
+// is reported as an EventBlocked.
+
+// An intent is a move or push asked for in the current tick.
+type intent struct {
+	player Player
+	dir    Direction
+	push   bool
+
+	// filled in when the tick is resolved
+	boulder   *Boulder // the pushed boulder
+	origin    MapPosition
+	target    MapPosition
+	needsFree bool // something is in the target cell and has to leave
+	onPlayer  bool // that something is a player
+	err       error
+}
+
+func (in *intent) blocked() error {
+	if in.push {
+		return ErrBoulderBlocked
+	}
+	if in.onPlayer {
+		return ErrPlayerOnField
+	}
+	return ErrBlocked
+}
+
+// HasIntent tells whether a move or push of player waits for its tick to be
+// resolved.
+func (g *Game) HasIntent(player Player) bool {
+	for _, in := range g.intents {
+		if in.player == player {
+			return true
+		}
+	}
+	return false
+}
+
+// passes tells whether the movers of two intents may end up in one cell.
+func (g *Game) passes(a, b *intent) bool {
+	if a.boulder != nil && b.boulder == nil {
+		a, b = b, a
+	}
+	return a.boulder == nil && b.boulder != nil && g.PlayerCanPassBoulder(a.player, b.boulder)
+}
+
+// checkIntent applies rule 1 against the level as it is before anything of
+// this tick moves.
+func (g *Game) checkIntent(in *intent) error {
+	playerPos := g.playerState[in.player].mapPos
+
+	if !in.push {
+		in.origin = playerPos
+		in.target = playerPos.Neighbor(in.dir)
+		if g.IsPlayer(in.target) {
+			in.needsFree = true
+			in.onPlayer = true
+		}
+		if !g.IsEmpty(in.target) {
+			if g.IsDoor(in.target) && g.Door(in.target).IsOpen() {
+				// door is open
+			} else if g.IsDoor(in.target) && g.PlayerCanPassDoor(in.player, g.doors[in.target]) {
+				// block door close
+			} else if g.IsBannWall(in.target) && g.PlayerCanPassBannWall(in.player, g.bannWalls[in.target]) {
+				// passes the bann wall
+			} else if g.IsBoulder(in.target) && g.PlayerCanPassBoulder(in.player, g.boulders[in.target]) {
+				// passes the boulder
+			} else if g.IsBoulder(in.target) {
+				in.needsFree = true
+			} else if g.IsDoor(in.target) || g.IsWall(in.target) {
+				// nothing leaving the cell opens it
+				return ErrBlocked
+			}
+		}
+		return nil
+	}
+
+	in.origin = playerPos.Neighbor(in.dir)
+	in.target = in.origin.Neighbor(in.dir)
+	in.boulder = g.boulders[in.origin]
+	if !g.PlayerCanPush(in.player, in.boulder) {
+		return ErrNotYourBoulder
+	}
+	if g.IsWall(in.target) || (g.IsDoor(in.target) && !g.Door(in.target).IsOpen()) {
+		return ErrBoulderBlocked
+	}
+	if g.IsPlayer(in.target) || g.IsBoulder(in.target) {
+		in.needsFree = true
+	}
+	return nil
+}
+
+// collides applies rules 2 and 4.
+func (g *Game) collides(in *intent, intents []*intent) bool {
+	for player, transition := range g.playerMoveTransition {
+		other := &intent{player: player}
+		if transition.TargetPos() == in.target && !g.passes(in, other) {
+			return true
+		}
+	}
+	for boulder, transition := range g.boulderTransition {
+		other := &intent{boulder: boulder}
+		if transition.TargetPos() == in.target && !g.passes(in, other) {
+			return true
+		}
+	}
+
+	for _, other := range intents {
+		if other == in || other.err != nil || g.passes(in, other) {
+			continue
+		}
+		if other.target == in.target || (other.target == in.origin && other.origin == in.target) {
+			return true
+		}
+	}
+	return false
+}
+
+// freed applies rule 3, the intents still standing may leave the cell.
+func (g *Game) freed(pos MapPosition, intents []*intent) bool {
+	for _, transition := range g.playerMoveTransition {
+		if transition.OriginPos() == pos {
+			return true
+		}
+	}
+	for _, transition := range g.boulderTransition {
+		if transition.OriginPos() == pos {
+			return true
+		}
+	}
+	for _, other := range intents {
+		if other.err == nil && other.origin == pos {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveIntents turns the intents of the tick into transitions.
+func (g *Game) resolveIntents() {
+	if len(g.intents) == 0 {
+		return
+	}
+	intents := g.intents
+	g.intents = nil
+	sort.SliceStable(intents, func(i, j int) bool { return intents[i].player < intents[j].player })
+
+	for _, in := range intents {
+		in.err = g.checkIntent(in)
+	}
+
+	// all collisions are found before any of them is decided
+	collided := make([]bool, len(intents))
+	for i, in := range intents {
+		collided[i] = in.err == nil && g.collides(in, intents)
+	}
+	for i, in := range intents {
+		if collided[i] {
+			in.err = in.blocked()
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, in := range intents {
+			if in.err == nil && in.needsFree && !g.freed(in.target, intents) {
+				in.err = in.blocked()
+				changed = true
+			}
+		}
+	}
+
+	for _, in := range intents {
+		if in.err != nil {
+			gameLog.Debug("Move blocked", "tick", g.tick, "player", in.player, "dir", in.dir, "push", in.push, "err", in.err)
+			g.event(EventBlocked, int(ReasonOf(in.err)), false, in.player)
+			continue
+		}
+
+		if in.push {
+			g.boulderTransition[in.boulder] = NewBoulderTransition(in.boulder, in.origin, in.target)
+			g.playerActionTransition[in.player] = NewPlayerActionTransition(in.player)
+		} else {
+			g.playerMoveTransition[in.player] = NewPlayerMoveTransition(in.player, in.origin, in.target)
+		}
+	}
+}
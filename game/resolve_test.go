@@ -0,0 +1,198 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// The cases play in the empty room at the top right of the level, x 9 to 13
+// and y 1 to 5. Boulder 1 is pushed by the human and passed by the ghost.
+
+type resolveAction struct {
+	player Player
+	action ActionType
+}
+
+type resolveCase struct {
+	name    string
+	human   MapPosition
+	ghost   MapPosition
+	looksIn Direction    // of both players, for pushes
+	boulder *MapPosition // where boulder 1 is put, nil leaves it out of the room
+	actions []resolveAction
+
+	wantHuman   MapPosition
+	wantGhost   MapPosition
+	wantBoulder *MapPosition
+	wantBlocked map[Player]DenyReason
+}
+
+func pos(x, y int) MapPosition {
+	return NewMapPosition(x, y)
+}
+
+func posPtr(x, y int) *MapPosition {
+	p := pos(x, y)
+	return &p
+}
+
+var resolveCases = []resolveCase{
+	{
+		name:  "same target",
+		human: pos(10, 3), ghost: pos(12, 3),
+		actions:   []resolveAction{{Human, ActionMoveEast}, {Ghost, ActionMoveWest}},
+		wantHuman: pos(10, 3), wantGhost: pos(12, 3),
+		wantBlocked: map[Player]DenyReason{Human: DenyBlocked, Ghost: DenyBlocked},
+	},
+	{
+		name:  "swap",
+		human: pos(10, 3), ghost: pos(11, 3),
+		actions:   []resolveAction{{Human, ActionMoveEast}, {Ghost, ActionMoveWest}},
+		wantHuman: pos(10, 3), wantGhost: pos(11, 3),
+		wantBlocked: map[Player]DenyReason{Human: DenyPlayerOnField, Ghost: DenyPlayerOnField},
+	},
+	{
+		name:  "follow the partner",
+		human: pos(10, 3), ghost: pos(11, 3),
+		actions:   []resolveAction{{Human, ActionMoveEast}, {Ghost, ActionMoveEast}},
+		wantHuman: pos(11, 3), wantGhost: pos(12, 3),
+	},
+	{
+		name:  "partner stays",
+		human: pos(10, 3), ghost: pos(11, 3),
+		actions:   []resolveAction{{Human, ActionMoveEast}, {Ghost, ActionLookNorth}},
+		wantHuman: pos(10, 3), wantGhost: pos(11, 3),
+		wantBlocked: map[Player]DenyReason{Human: DenyPlayerOnField},
+	},
+	{
+		name:  "chain of a push and a move",
+		human: pos(10, 3), ghost: pos(12, 3), looksIn: DirEast, boulder: posPtr(11, 3),
+		actions:   []resolveAction{{Human, ActionAction}, {Ghost, ActionMoveEast}},
+		wantHuman: pos(10, 3), wantGhost: pos(13, 3), wantBoulder: posPtr(12, 3),
+	},
+	{
+		name:  "push onto a partner who stays",
+		human: pos(10, 3), ghost: pos(12, 3), looksIn: DirEast, boulder: posPtr(11, 3),
+		actions:   []resolveAction{{Human, ActionAction}, {Ghost, ActionLookWest}},
+		wantHuman: pos(10, 3), wantGhost: pos(12, 3), wantBoulder: posPtr(11, 3),
+		wantBlocked: map[Player]DenyReason{Human: DenyBoulderBlocked},
+	},
+	{
+		name:  "ghost passes a boulder",
+		human: pos(12, 4), ghost: pos(10, 3), boulder: posPtr(11, 3),
+		actions:   []resolveAction{{Ghost, ActionMoveEast}, {Human, ActionMoveNorth}},
+		wantHuman: pos(12, 3), wantGhost: pos(11, 3), wantBoulder: posPtr(11, 3),
+	},
+	{
+		name:  "ghost and a boulder share the target",
+		human: pos(9, 3), ghost: pos(12, 3), looksIn: DirEast, boulder: posPtr(10, 3),
+		actions:   []resolveAction{{Human, ActionAction}, {Ghost, ActionMoveWest}},
+		wantHuman: pos(9, 3), wantGhost: pos(11, 3), wantBoulder: posPtr(11, 3),
+	},
+	{
+		name:  "human cannot pass the boulder the ghost stands on",
+		human: pos(12, 3), ghost: pos(10, 3), boulder: posPtr(11, 3),
+		actions:   []resolveAction{{Human, ActionMoveWest}, {Ghost, ActionLookNorth}},
+		wantHuman: pos(12, 3), wantGhost: pos(10, 3), wantBoulder: posPtr(11, 3),
+		wantBlocked: map[Player]DenyReason{Human: DenyBlocked},
+	},
+}
+
+// resolveGame sets up the level for the case.
+func resolveGame(t *testing.T, c resolveCase) *Game {
+	g, err := NewGame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.NewPlayer(int(Human))
+	g.NewPlayer(int(Ghost))
+
+	s := g.Snapshot()
+	for i, _ := range s.Players {
+		s.Players[i].LooksIn = c.looksIn
+		if s.Players[i].Player == Human {
+			s.Players[i].Pos = NewSnapshotPos(c.human)
+		} else {
+			s.Players[i].Pos = NewSnapshotPos(c.ghost)
+		}
+	}
+	for i, _ := range s.Boulders {
+		if s.Boulders[i].ID == 1 && c.boulder != nil {
+			s.Boulders[i].Pos = NewSnapshotPos(*c.boulder)
+		}
+	}
+	if err := g.RestoreSnapshot(s); err != nil {
+		t.Fatal(err)
+	}
+	g.RecordEvents()
+	return g
+}
+
+// play performs the actions in one tick in the order they are given, like
+// the server does when they arrive, and lets the moves finish.
+func play(t *testing.T, c resolveCase, actions []resolveAction) (*Game, map[Player]DenyReason) {
+	g := resolveGame(t, c)
+	for _, a := range actions {
+		if err := g.PerformPlayerAction(a.player, a.action); err != nil {
+			t.Fatalf("%s: %s %s refused: %v", c.name, RoleName(a.player), a.action, err)
+		}
+	}
+	g.StepTo(g.Tick() + Tick(3*time.Second/TickDuration))
+
+	blocked := make(map[Player]DenyReason)
+	for _, e := range g.TakeEvents() {
+		if e.Kind == EventBlocked {
+			blocked[e.Player] = DenyReason(e.Id)
+		}
+	}
+	return g, blocked
+}
+
+func boulderPos(g *Game, id BoulderID) *MapPosition {
+	for pos, boulder := range g.boulders {
+		if boulder.id == id {
+			return &pos
+		}
+	}
+	return nil
+}
+
+func TestResolveIntents(t *testing.T) {
+	for _, c := range resolveCases {
+		reversed := make([]resolveAction, len(c.actions))
+		for i, a := range c.actions {
+			reversed[len(c.actions)-1-i] = a
+		}
+
+		g, blocked := play(t, c, c.actions)
+		other, otherBlocked := play(t, c, reversed)
+
+		if !reflect.DeepEqual(g.Snapshot(), other.Snapshot()) {
+			t.Errorf("%s: the order of the actions matters", c.name)
+		}
+		if !reflect.DeepEqual(blocked, otherBlocked) {
+			t.Errorf("%s: blocked %v in one order, %v in the other", c.name, blocked, otherBlocked)
+		}
+
+		if pos := g.playerState[Human].mapPos; pos != c.wantHuman {
+			t.Errorf("%s: human at %v, want %v", c.name, pos, c.wantHuman)
+		}
+		if pos := g.playerState[Ghost].mapPos; pos != c.wantGhost {
+			t.Errorf("%s: ghost at %v, want %v", c.name, pos, c.wantGhost)
+		}
+		if c.wantBoulder != nil {
+			if pos := boulderPos(g, 1); pos == nil || *pos != *c.wantBoulder {
+				t.Errorf("%s: boulder at %v, want %v", c.name, pos, *c.wantBoulder)
+			}
+		}
+
+		want := c.wantBlocked
+		if want == nil {
+			want = make(map[Player]DenyReason)
+		}
+		if !reflect.DeepEqual(blocked, want) {
+			t.Errorf("%s: blocked %v, want %v", c.name, blocked, want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SaveVersion is increased whenever old save files can no longer be read.
+const SaveVersion = 1
+
+// SaveFile is a game in progress as it is written to disk.
+type SaveFile struct {
+	Version int
+	Level   string
+	Saved   time.Time
+	Players []SavedPlayer
+	State   *GameSnapshot
+}
+
+// SavedPlayer remembers who played which role.
+type SavedPlayer struct {
+	Player   Player
+	Nick     string
+	PlayerId string
+}
+
+func (g *Game) Save(w io.Writer, players []SavedPlayer) error {
+	save := SaveFile{
+		Version: SaveVersion,
+		Level:   g.LevelId(),
+		Saved:   time.Now(),
+		Players: players,
+		State:   g.Snapshot(),
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(save)
+}
+
+// SaveToFile writes the save next to path first and renames it, a crash never
+// leaves a half written save behind.
+func (g *Game) SaveToFile(path string, players []SavedPlayer) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), ".save")
+	if err != nil {
+		return err
+	}
+
+	if err := g.Save(file, players); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+func ReadSave(r io.Reader) (*SaveFile, error) {
+	var save SaveFile
+	if err := json.NewDecoder(r).Decode(&save); err != nil {
+		return nil, err
+	}
+
+	if save.Version != SaveVersion {
+		return nil, errors.New("Unsupported save version")
+	}
+
+	if save.Level != GlobalConfig.levelId {
+		return nil, errors.New("Save is from a different level")
+	}
+
+	if save.State == nil {
+		return nil, errors.New("Save has no game state")
+	}
+
+	return &save, nil
+}
+
+func ReadSaveFile(path string) (*SaveFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadSave(file)
+}
+
+// LoadGame builds a fresh game and puts it into the saved state.
+func LoadGame(save *SaveFile) (*Game, error) {
+	g, err := NewGame()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := g.RestoreSnapshot(save.State); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
@@ -0,0 +1,771 @@
+package game
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// A GameSnapshot holds everything that changes while a level is played. It
+// only makes sense together with the level it was taken from.
+type GameSnapshot struct {
+	Players     []PlayerSnapshot
+	Rooms       []RoomSnapshot
+	Doors       []DoorSnapshot
+	Triggers    []TriggerSnapshot
+	Plates      []PlateSnapshot
+	BannWalls   []BannWallSnapshot
+	Boulders    []BoulderSnapshot
+	Transitions []TransitionSnapshot
+	Tick        Tick
+	Scheduled   []TickAction
+	Intents     []IntentSnapshot // moves and pushes not resolved yet
+	Completed   bool
+}
+
+type SnapshotPos struct {
+	X int
+	Y int
+}
+
+func NewSnapshotPos(pos MapPosition) SnapshotPos {
+	return SnapshotPos{X: pos.x, Y: pos.y}
+}
+
+func (sp SnapshotPos) MapPosition() MapPosition {
+	return MapPosition{sp.X, sp.Y}
+}
+
+type PlayerSnapshot struct {
+	Player  Player
+	Pos     SnapshotPos
+	LooksIn Direction
+	Vis     PlayerVisSnapshot
+	Cans    PlayerCansSnapshot
+}
+
+// Only the entries that are true are stored.
+type PlayerVisSnapshot struct {
+	Cells     []SnapshotPos
+	Doors     []DoorID
+	Triggers  []TriggerID
+	BannWalls []BannWallID
+	Boulders  []BoulderID
+	Plates    []PlateID
+	Player    bool
+}
+
+type PlayerCansSnapshot struct {
+	PassDoors     []DoorID
+	PassBoulders  []BoulderID
+	PassBannWalls []BannWallID
+	Push          []BoulderID
+	Trigger       []TriggerID
+	Pressure      []PlateID
+}
+
+type RoomSnapshot struct {
+	ID      RoomID
+	Visible bool
+}
+
+type DoorSnapshot struct {
+	ID   DoorID
+	Open bool
+}
+
+type TriggerSnapshot struct {
+	ID     TriggerID
+	Active bool
+}
+
+type PlateSnapshot struct {
+	ID     PlateID
+	Active bool
+}
+
+type BannWallSnapshot struct {
+	ID     BannWallID
+	Active bool
+}
+
+type BoulderSnapshot struct {
+	ID     BoulderID
+	Pos    SnapshotPos
+	Active bool
+}
+
+type IntentSnapshot struct {
+	Player Player
+	Dir    Direction
+	Push   bool
+}
+
+type TransitionKind int
+
+const (
+	TransitionPlayerMove TransitionKind = iota
+	TransitionPlayerAction
+	TransitionBoulder
+	TransitionVis
+	TransitionVisDelay
+)
+
+type TransitionSnapshot struct {
+	Kind    TransitionKind
+	Player  Player
+	Boulder BoulderID
+	From    SnapshotPos
+	To      SnapshotPos
+	DTime   time.Duration
+}
+
+func (g *Game) Snapshot() *GameSnapshot {
+	s := &GameSnapshot{Tick: g.tick, Completed: g.completed}
+
+	s.Scheduled = make([]TickAction, len(g.scheduled))
+	copy(s.Scheduled, g.scheduled)
+
+	for _, in := range g.intents {
+		s.Intents = append(s.Intents, IntentSnapshot{in.player, in.dir, in.push})
+	}
+
+	for _, player := range g.players {
+		state := g.playerState[player]
+		s.Players = append(s.Players, PlayerSnapshot{
+			Player:  player,
+			Pos:     NewSnapshotPos(state.mapPos),
+			LooksIn: state.looksIn,
+			Vis:     snapshotVis(g.playerVis[player]),
+			Cans:    snapshotCans(g.playerCans[player]),
+		})
+	}
+	sort.Slice(s.Players, func(i, j int) bool { return s.Players[i].Player < s.Players[j].Player })
+
+	for _, room := range g.rooms {
+		s.Rooms = append(s.Rooms, RoomSnapshot{room.id, room.isVisible})
+	}
+	sort.Slice(s.Rooms, func(i, j int) bool { return s.Rooms[i].ID < s.Rooms[j].ID })
+
+	for _, door := range g.doors {
+		s.Doors = append(s.Doors, DoorSnapshot{door.id, door.isOpen})
+	}
+	sort.Slice(s.Doors, func(i, j int) bool { return s.Doors[i].ID < s.Doors[j].ID })
+
+	for _, trigger := range g.triggers {
+		s.Triggers = append(s.Triggers, TriggerSnapshot{trigger.id, trigger.isActive})
+	}
+	sort.Slice(s.Triggers, func(i, j int) bool { return s.Triggers[i].ID < s.Triggers[j].ID })
+
+	for _, plate := range g.plates {
+		s.Plates = append(s.Plates, PlateSnapshot{plate.id, plate.isActive})
+	}
+	sort.Slice(s.Plates, func(i, j int) bool { return s.Plates[i].ID < s.Plates[j].ID })
+
+	for _, bannWall := range g.bannWalls {
+		s.BannWalls = append(s.BannWalls, BannWallSnapshot{bannWall.id, bannWall.isActive})
+	}
+	sort.Slice(s.BannWalls, func(i, j int) bool { return s.BannWalls[i].ID < s.BannWalls[j].ID })
+
+	for pos, boulder := range g.boulders {
+		s.Boulders = append(s.Boulders, BoulderSnapshot{boulder.id, NewSnapshotPos(pos), boulder.active})
+	}
+	sort.Slice(s.Boulders, func(i, j int) bool { return s.Boulders[i].ID < s.Boulders[j].ID })
+
+	s.Transitions = g.snapshotTransitions()
+	return s
+}
+
+func (g *Game) snapshotTransitions() []TransitionSnapshot {
+	var transitions []TransitionSnapshot
+
+	for player, transition := range g.playerMoveTransition {
+		pmt := transition.(*PlayerMoveTransition)
+		transitions = append(transitions, TransitionSnapshot{
+			Kind:   TransitionPlayerMove,
+			Player: player,
+			From:   NewSnapshotPos(pmt.fromPos),
+			To:     NewSnapshotPos(pmt.toPos),
+			DTime:  pmt.dtime,
+		})
+	}
+
+	for player, transition := range g.playerActionTransition {
+		pat := transition.(*PlayerActionTransition)
+		transitions = append(transitions, TransitionSnapshot{
+			Kind:   TransitionPlayerAction,
+			Player: player,
+			DTime:  pat.dtime,
+		})
+	}
+
+	for boulder, transition := range g.boulderTransition {
+		bt := transition.(*BoulderTransition)
+		transitions = append(transitions, TransitionSnapshot{
+			Kind:    TransitionBoulder,
+			Boulder: boulder.id,
+			From:    NewSnapshotPos(bt.fromPos),
+			To:      NewSnapshotPos(bt.toPos),
+			DTime:   bt.dtime,
+		})
+	}
+
+	for player, vt := range g.playerVisTransition {
+		transitions = append(transitions, TransitionSnapshot{
+			Kind:   TransitionVis,
+			Player: player,
+			DTime:  vt.dtime,
+		})
+	}
+
+	for player, vd := range g.visDelay {
+		transitions = append(transitions, TransitionSnapshot{
+			Kind:   TransitionVisDelay,
+			Player: player,
+			DTime:  vd.dtime,
+		})
+	}
+
+	sort.Slice(transitions, func(i, j int) bool {
+		a, b := transitions[i], transitions[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Player != b.Player {
+			return a.Player < b.Player
+		}
+		return a.Boulder < b.Boulder
+	})
+
+	return transitions
+}
+
+func snapshotVis(vis *PlayerVis) PlayerVisSnapshot {
+	s := PlayerVisSnapshot{Player: vis.visPlayer}
+
+	for pos, visible := range vis.visCell {
+		if visible {
+			s.Cells = append(s.Cells, NewSnapshotPos(pos))
+		}
+	}
+	sort.Slice(s.Cells, func(i, j int) bool {
+		if s.Cells[i].Y != s.Cells[j].Y {
+			return s.Cells[i].Y < s.Cells[j].Y
+		}
+		return s.Cells[i].X < s.Cells[j].X
+	})
+
+	for door, visible := range vis.visDoor {
+		if visible {
+			s.Doors = append(s.Doors, door.id)
+		}
+	}
+	for trigger, visible := range vis.visTrigger {
+		if visible {
+			s.Triggers = append(s.Triggers, trigger.id)
+		}
+	}
+	for bannWall, visible := range vis.visBannWall {
+		if visible {
+			s.BannWalls = append(s.BannWalls, bannWall.id)
+		}
+	}
+	for boulder, visible := range vis.visBoulder {
+		if visible {
+			s.Boulders = append(s.Boulders, boulder.id)
+		}
+	}
+	for plate, visible := range vis.visPlate {
+		if visible {
+			s.Plates = append(s.Plates, plate.id)
+		}
+	}
+
+	sortIds(s.Doors, s.Triggers, s.BannWalls, s.Boulders, s.Plates)
+	return s
+}
+
+func snapshotCans(cans *PlayerCans) PlayerCansSnapshot {
+	s := PlayerCansSnapshot{}
+
+	for door, can := range cans.canPassDoor {
+		if can {
+			s.PassDoors = append(s.PassDoors, door.id)
+		}
+	}
+	for boulder, can := range cans.canPassBoulder {
+		if can {
+			s.PassBoulders = append(s.PassBoulders, boulder.id)
+		}
+	}
+	for bannWall, can := range cans.canPassBannWall {
+		if can {
+			s.PassBannWalls = append(s.PassBannWalls, bannWall.id)
+		}
+	}
+	for boulder, can := range cans.canPush {
+		if can {
+			s.Push = append(s.Push, boulder.id)
+		}
+	}
+	for trigger, can := range cans.canTrigger {
+		if can {
+			s.Trigger = append(s.Trigger, trigger.id)
+		}
+	}
+	for plate, can := range cans.canPressure {
+		if can {
+			s.Pressure = append(s.Pressure, plate.id)
+		}
+	}
+
+	sortIds(s.PassDoors, s.PassBoulders, s.PassBannWalls, s.Push, s.Trigger, s.Pressure)
+	return s
+}
+
+func sortIds(lists ...interface{}) {
+	for _, list := range lists {
+		switch ids := list.(type) {
+		case []DoorID:
+			sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		case []TriggerID:
+			sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		case []BannWallID:
+			sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		case []BoulderID:
+			sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		case []PlateID:
+			sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		}
+	}
+}
+
+func (g *Game) roomById(id RoomID) *Room {
+	for _, room := range g.rooms {
+		if room.id == id {
+			return room
+		}
+	}
+	return nil
+}
+
+func (g *Game) doorById(id DoorID) *Door {
+	for _, door := range g.doors {
+		if door.id == id {
+			return door
+		}
+	}
+	return nil
+}
+
+func (g *Game) triggerById(id TriggerID) *Trigger {
+	for _, trigger := range g.triggers {
+		if trigger.id == id {
+			return trigger
+		}
+	}
+	return nil
+}
+
+func (g *Game) plateById(id PlateID) *Plate {
+	for _, plate := range g.plates {
+		if plate.id == id {
+			return plate
+		}
+	}
+	return nil
+}
+
+func (g *Game) bannWallById(id BannWallID) *BannWall {
+	for _, bannWall := range g.bannWalls {
+		if bannWall.id == id {
+			return bannWall
+		}
+	}
+	return nil
+}
+
+func (g *Game) boulderById(id BoulderID) *Boulder {
+	for _, boulder := range g.boulders {
+		if boulder.id == id {
+			return boulder
+		}
+	}
+	return nil
+}
+
+// checkSnapshot makes sure every id, player and position in s belongs to
+// the level, so restoring it cannot fail halfway. Snapshots come from save
+// files too.
+func (g *Game) checkSnapshot(s *GameSnapshot) error {
+	inside := func(pos SnapshotPos) bool {
+		return pos.X >= 0 && pos.Y >= 0 && pos.X < g.Width() && pos.Y < g.Height()
+	}
+	knownPlayer := func(player Player) bool {
+		return player == Human || player == Ghost
+	}
+	doors := func(ids []DoorID) error {
+		for _, id := range ids {
+			if g.doorById(id) == nil {
+				return errors.New("Unknown door")
+			}
+		}
+		return nil
+	}
+	triggers := func(ids []TriggerID) error {
+		for _, id := range ids {
+			if g.triggerById(id) == nil {
+				return errors.New("Unknown trigger")
+			}
+		}
+		return nil
+	}
+	plates := func(ids []PlateID) error {
+		for _, id := range ids {
+			if g.plateById(id) == nil {
+				return errors.New("Unknown plate")
+			}
+		}
+		return nil
+	}
+	bannWalls := func(ids []BannWallID) error {
+		for _, id := range ids {
+			if g.bannWallById(id) == nil {
+				return errors.New("Unknown bann wall")
+			}
+		}
+		return nil
+	}
+	boulders := func(ids []BoulderID) error {
+		for _, id := range ids {
+			if g.boulderById(id) == nil {
+				return errors.New("Unknown boulder")
+			}
+		}
+		return nil
+	}
+
+	for _, rs := range s.Rooms {
+		if g.roomById(rs.ID) == nil {
+			return errors.New("Unknown room")
+		}
+	}
+	for _, ds := range s.Doors {
+		if err := doors([]DoorID{ds.ID}); err != nil {
+			return err
+		}
+	}
+	for _, ts := range s.Triggers {
+		if err := triggers([]TriggerID{ts.ID}); err != nil {
+			return err
+		}
+	}
+	for _, ps := range s.Plates {
+		if err := plates([]PlateID{ps.ID}); err != nil {
+			return err
+		}
+	}
+	for _, bs := range s.BannWalls {
+		if err := bannWalls([]BannWallID{bs.ID}); err != nil {
+			return err
+		}
+	}
+	boulderIds := make(map[BoulderID]bool)
+	boulderCells := make(map[SnapshotPos]bool)
+	for _, bs := range s.Boulders {
+		if err := boulders([]BoulderID{bs.ID}); err != nil {
+			return err
+		}
+		if !inside(bs.Pos) {
+			return errors.New("Boulder outside the level")
+		}
+		if boulderIds[bs.ID] {
+			return errors.New("Boulder stored twice")
+		}
+		if boulderCells[bs.Pos] {
+			return errors.New("Two boulders on one cell")
+		}
+		boulderIds[bs.ID] = true
+		boulderCells[bs.Pos] = true
+	}
+
+	players := make(map[Player]bool)
+	for _, ps := range s.Players {
+		if !knownPlayer(ps.Player) {
+			return errors.New("Unknown player")
+		}
+		if players[ps.Player] {
+			return errors.New("Player stored twice")
+		}
+		players[ps.Player] = true
+		if !inside(ps.Pos) {
+			return errors.New("Player outside the level")
+		}
+		if !ps.LooksIn.Valid() {
+			return errors.New("Unknown direction")
+		}
+		for _, pos := range ps.Vis.Cells {
+			if !inside(pos) {
+				return errors.New("Visible cell outside the level")
+			}
+		}
+		for _, err := range []error{
+			doors(ps.Vis.Doors), triggers(ps.Vis.Triggers), bannWalls(ps.Vis.BannWalls),
+			boulders(ps.Vis.Boulders), plates(ps.Vis.Plates),
+			doors(ps.Cans.PassDoors), boulders(ps.Cans.PassBoulders), bannWalls(ps.Cans.PassBannWalls),
+			boulders(ps.Cans.Push), triggers(ps.Cans.Trigger), plates(ps.Cans.Pressure),
+		} {
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, ts := range s.Transitions {
+		switch ts.Kind {
+		case TransitionBoulder:
+			if err := boulders([]BoulderID{ts.Boulder}); err != nil {
+				return err
+			}
+		case TransitionPlayerMove, TransitionPlayerAction, TransitionVis, TransitionVisDelay:
+			if !players[ts.Player] {
+				return errors.New("Transition of a player who is not in the game")
+			}
+		default:
+			return errors.New("Unknown transition")
+		}
+		if !inside(ts.From) || !inside(ts.To) {
+			return errors.New("Transition outside the level")
+		}
+	}
+
+	for _, ta := range s.Scheduled {
+		if !players[ta.Player] {
+			return errors.New("Action of a player who is not in the game")
+		}
+		if !ta.Action.Valid() {
+			return errors.New("Unknown action")
+		}
+	}
+
+	for _, is := range s.Intents {
+		if !players[is.Player] {
+			return errors.New("Intent of a player who is not in the game")
+		}
+		if !is.Dir.Valid() {
+			return errors.New("Unknown direction")
+		}
+	}
+	return nil
+}
+
+// RestoreSnapshot puts the game into the state stored in s. The game has to
+// be built from the same level the snapshot was taken from. Nothing is
+// changed if s does not fit the level.
+func (g *Game) RestoreSnapshot(s *GameSnapshot) error {
+	if err := g.checkSnapshot(s); err != nil {
+		return err
+	}
+
+	for _, rs := range s.Rooms {
+		g.roomById(rs.ID).isVisible = rs.Visible
+	}
+	for _, ds := range s.Doors {
+		g.doorById(ds.ID).isOpen = ds.Open
+	}
+	for _, ts := range s.Triggers {
+		g.triggerById(ts.ID).isActive = ts.Active
+	}
+	for _, ps := range s.Plates {
+		g.plateById(ps.ID).isActive = ps.Active
+	}
+	for _, bs := range s.BannWalls {
+		g.bannWallById(bs.ID).isActive = bs.Active
+	}
+
+	boulders := make(map[MapPosition]*Boulder)
+	for _, bs := range s.Boulders {
+		boulder := g.boulderById(bs.ID)
+		boulder.active = bs.Active
+		boulders[bs.Pos.MapPosition()] = boulder
+	}
+	g.boulders = boulders
+
+	g.restorePlayers(s.Players)
+	if err := g.restoreTransitions(s.Transitions); err != nil {
+		return err
+	}
+
+	g.tick = s.Tick
+	g.scheduled = make([]TickAction, len(s.Scheduled))
+	copy(g.scheduled, s.Scheduled)
+	g.intents = nil
+	for _, is := range s.Intents {
+		g.intents = append(g.intents, &intent{player: is.Player, dir: is.Dir, push: is.Push})
+	}
+	g.pending = 0
+	g.completed = s.Completed
+	if g.hashes != nil {
+		g.hashes = make(map[Tick]uint64)
+	}
+	return nil
+}
+
+func (g *Game) restorePlayers(players []PlayerSnapshot) {
+	keep := make(map[Player]bool)
+	for _, ps := range players {
+		keep[ps.Player] = true
+	}
+
+	remaining := make([]Player, 0, 2)
+	for _, player := range g.players {
+		if keep[player] {
+			remaining = append(remaining, player)
+		} else {
+			delete(g.playerState, player)
+			delete(g.playerCans, player)
+			delete(g.playerVis, player)
+		}
+	}
+	g.players = remaining
+
+	for _, ps := range players {
+		if _, ok := g.playerState[ps.Player]; !ok {
+			g.NewPlayer(int(ps.Player))
+		}
+
+		state := g.playerState[ps.Player]
+		state.mapPos = ps.Pos.MapPosition()
+		state.looksIn = ps.LooksIn
+
+		vis := NewPlayerVis()
+		for y := 0; y < g.Height(); y++ {
+			for x := 0; x < g.Width(); x++ {
+				vis.visCell[NewMapPosition(x, y)] = false
+			}
+		}
+		for _, pos := range ps.Vis.Cells {
+			vis.visCell[pos.MapPosition()] = true
+		}
+		for _, door := range g.doors {
+			vis.visDoor[door] = false
+		}
+		for _, id := range ps.Vis.Doors {
+			vis.visDoor[g.doorById(id)] = true
+		}
+		for _, trigger := range g.triggers {
+			vis.visTrigger[trigger] = false
+		}
+		for _, id := range ps.Vis.Triggers {
+			vis.visTrigger[g.triggerById(id)] = true
+		}
+		for _, bannWall := range g.bannWalls {
+			vis.visBannWall[bannWall] = false
+		}
+		for _, id := range ps.Vis.BannWalls {
+			vis.visBannWall[g.bannWallById(id)] = true
+		}
+		for _, boulder := range g.boulders {
+			vis.visBoulder[boulder] = false
+		}
+		for _, id := range ps.Vis.Boulders {
+			vis.visBoulder[g.boulderById(id)] = true
+		}
+		for _, plate := range g.plates {
+			vis.visPlate[plate] = false
+		}
+		for _, id := range ps.Vis.Plates {
+			vis.visPlate[g.plateById(id)] = true
+		}
+		vis.visPlayer = ps.Vis.Player
+		g.playerVis[ps.Player] = vis
+
+		cans := NewPlayerCans()
+		for _, door := range g.doors {
+			cans.canPassDoor[door] = false
+		}
+		for _, id := range ps.Cans.PassDoors {
+			cans.canPassDoor[g.doorById(id)] = true
+		}
+		for _, boulder := range g.boulders {
+			cans.canPassBoulder[boulder] = false
+			cans.canPush[boulder] = false
+		}
+		for _, id := range ps.Cans.PassBoulders {
+			cans.canPassBoulder[g.boulderById(id)] = true
+		}
+		for _, id := range ps.Cans.Push {
+			cans.canPush[g.boulderById(id)] = true
+		}
+		for _, bannWall := range g.bannWalls {
+			cans.canPassBannWall[bannWall] = false
+		}
+		for _, id := range ps.Cans.PassBannWalls {
+			cans.canPassBannWall[g.bannWallById(id)] = true
+		}
+		for _, trigger := range g.triggers {
+			cans.canTrigger[trigger] = false
+		}
+		for _, id := range ps.Cans.Trigger {
+			cans.canTrigger[g.triggerById(id)] = true
+		}
+		for _, plate := range g.plates {
+			cans.canPressure[plate] = false
+		}
+		for _, id := range ps.Cans.Pressure {
+			cans.canPressure[g.plateById(id)] = true
+		}
+		g.playerCans[ps.Player] = cans
+	}
+}
+
+func (g *Game) restoreTransitions(transitions []TransitionSnapshot) error {
+	g.playerMoveTransition = make(map[Player]MoveableTransition)
+	g.playerActionTransition = make(map[Player]AnimTransition)
+	g.boulderTransition = make(map[*Boulder]MoveableTransition)
+	g.playerVisTransition = make(map[Player]*VisStateTransition)
+	g.visDelay = make(map[Player]*VisDelay)
+
+	for _, ts := range transitions {
+		switch ts.Kind {
+		case TransitionPlayerMove:
+			pmt := NewPlayerMoveTransition(ts.Player, ts.From.MapPosition(), ts.To.MapPosition())
+			pmt.dtime = ts.DTime
+			g.playerMoveTransition[ts.Player] = pmt
+		case TransitionPlayerAction:
+			pat := NewPlayerActionTransition(ts.Player)
+			pat.dtime = ts.DTime
+			g.playerActionTransition[ts.Player] = pat
+		case TransitionBoulder:
+			boulder := g.boulderById(ts.Boulder)
+			if boulder == nil {
+				return errors.New("Unknown boulder")
+			}
+			bt := NewBoulderTransition(boulder, ts.From.MapPosition(), ts.To.MapPosition())
+			bt.dtime = ts.DTime
+			g.boulderTransition[boulder] = bt
+		case TransitionVis:
+			vt := NewVisStateTransition(ts.Player)
+			vt.dtime = ts.DTime
+			g.playerVisTransition[ts.Player] = vt
+		case TransitionVisDelay:
+			vd := NewVisDelay(ts.Player)
+			vd.dtime = ts.DTime
+			g.visDelay[ts.Player] = vd
+		default:
+			return errors.New("Unknown transition")
+		}
+	}
+
+	return nil
+}
+
+// Rewind puts the game back into the state of an earlier snapshot. Only the
+// state goes back, the tick keeps counting and nothing stays scheduled.
+func (g *Game) Rewind(s *GameSnapshot) error {
+	rewound := *s
+	rewound.Tick = g.tick
+	rewound.Scheduled = nil
+	rewound.Intents = nil
+	return g.RestoreSnapshot(&rewound)
+}
@@ -0,0 +1,107 @@
+package game
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// The simulation advances in fixed ticks. Actions are scheduled for a tick and
+// performed right before that tick is simulated, so the same actions on the
+// same ticks always lead to the same game.
+const TickDuration = 20 * time.Millisecond
+
+type Tick uint64
+
+type TickAction struct {
+	Tick   Tick
+	Player Player
+	Action ActionType
+}
+
+// Tick is the number of ticks simulated so far.
+func (g *Game) Tick() Tick {
+	return g.tick
+}
+
+func (g *Game) ScheduleAction(tick Tick, player Player, action ActionType) error {
+	if tick < g.tick {
+		return errors.New("Action scheduled for a past tick")
+	}
+
+	g.scheduled = append(g.scheduled, TickAction{tick, player, action})
+	return nil
+}
+
+// Step performs the actions scheduled for the current tick, ordered by
+// player, resolves their moves, simulates one tick and checks whether the
+// level is completed.
+func (g *Game) Step() {
+	due := make([]TickAction, 0)
+	later := make([]TickAction, 0, len(g.scheduled))
+	for _, ta := range g.scheduled {
+		if ta.Tick == g.tick {
+			due = append(due, ta)
+		} else {
+			later = append(later, ta)
+		}
+	}
+	g.scheduled = later
+
+	sort.SliceStable(due, func(i, j int) bool { return due[i].Player < due[j].Player })
+	for _, ta := range due {
+		if err := g.PerformPlayerAction(ta.Player, ta.Action); err != nil {
+			gameLog.Debug("Scheduled action failed", "tick", ta.Tick, "player", ta.Player, "action", ta.Action, "err", err)
+		}
+	}
+
+	g.resolveIntents()
+	g.simulate(TickDuration)
+	g.checkCompleted()
+	g.tick++
+	g.recordHash()
+}
+
+// StepTo simulates until tick is reached, it never goes back in time. Ticks
+// in which nothing can happen are skipped.
+func (g *Game) StepTo(tick Tick) {
+	for g.tick < tick {
+		if g.isIdle() {
+			next := tick
+			for _, ta := range g.scheduled {
+				if ta.Tick >= g.tick && ta.Tick < next {
+					next = ta.Tick
+				}
+			}
+			if checkpoint := (g.tick/HashInterval + 1) * HashInterval; g.hashes != nil && checkpoint < next {
+				next = checkpoint
+			}
+			g.tick = next
+			g.recordHash()
+			if g.tick == tick {
+				return
+			}
+		}
+		g.Step()
+	}
+}
+
+func (g *Game) isIdle() bool {
+	return len(g.intents) == 0 &&
+		len(g.playerMoveTransition) == 0 &&
+		len(g.playerActionTransition) == 0 &&
+		len(g.boulderTransition) == 0 &&
+		len(g.doorTransition) == 0 &&
+		len(g.playerVisTransition) == 0 &&
+		len(g.visDelay) == 0
+}
+
+// Update simulates as many ticks as fit into t, the rest is carried over to
+// the next call.
+func (g *Game) Update(t time.Duration) {
+	g.pending += t
+	for g.pending >= TickDuration {
+		g.pending -= TickDuration
+		g.Step()
+	}
+}
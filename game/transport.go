@@ -0,0 +1,105 @@
+package game
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"net"
+	"time"
+)
+
+// Transport carries the protocol between client and server. Every Encode
+// sends one protocol value, every Decode reads one.
+type Transport interface {
+	Encode(v interface{}) error
+	Decode(v interface{}) error
+	SetDeadline(t time.Time) error
+	RemoteAddr() net.Addr
+	Close() error
+}
+
+// GobTransport is the native protocol: gob values over a TCP stream.
+type GobTransport struct {
+	conn  net.Conn
+	enc   *gob.Encoder
+	dec   *gob.Decoder
+	limit *limitedReader // nil if values may have any size
+}
+
+func NewGobTransport(conn net.Conn) *GobTransport {
+	return &GobTransport{
+		conn: conn,
+		enc:  gob.NewEncoder(conn),
+		dec:  gob.NewDecoder(conn),
+	}
+}
+
+// NewLimitedGobTransport fails every Decode that reads more than limit bytes,
+// for connections from untrusted peers.
+func NewLimitedGobTransport(conn net.Conn, limit int) *GobTransport {
+	reader := &limitedReader{reader: bufio.NewReader(conn), max: limit}
+	return &GobTransport{
+		conn:  conn,
+		enc:   gob.NewEncoder(conn),
+		dec:   gob.NewDecoder(reader),
+		limit: reader,
+	}
+}
+
+// limitedReader counts the bytes of one decoded value. It is a ByteReader so
+// gob does not buffer ahead of the value.
+type limitedReader struct {
+	reader *bufio.Reader
+	max    int
+	left   int
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.left <= 0 {
+		return 0, errors.New("Message too large")
+	}
+	if len(p) > lr.left {
+		p = p[:lr.left]
+	}
+	n, err := lr.reader.Read(p)
+	lr.left -= n
+	return n, err
+}
+
+func (lr *limitedReader) ReadByte() (byte, error) {
+	if lr.left <= 0 {
+		return 0, errors.New("Message too large")
+	}
+	lr.left--
+	return lr.reader.ReadByte()
+}
+
+func (gt *GobTransport) Encode(v interface{}) error {
+	return gt.enc.Encode(v)
+}
+
+func (gt *GobTransport) Decode(v interface{}) error {
+	if gt.limit != nil {
+		gt.limit.left = gt.limit.max
+	}
+	return gt.dec.Decode(v)
+}
+
+func (gt *GobTransport) SetDeadline(t time.Time) error {
+	return gt.conn.SetDeadline(t)
+}
+
+func (gt *GobTransport) RemoteAddr() net.Addr {
+	return gt.conn.RemoteAddr()
+}
+
+func (gt *GobTransport) Close() error {
+	return gt.conn.Close()
+}
+
+// NewPipe connects a client and a server transport in memory, for running
+// both in one process. The values are encoded like on the network.
+func NewPipe() (client Transport, server Transport) {
+	clientConn, serverConn := net.Pipe()
+	return NewGobTransport(clientConn), NewGobTransport(serverConn)
+}
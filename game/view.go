@@ -0,0 +1,249 @@
+package game
+
+import (
+	"sort"
+)
+
+// A PlayerView is what one player may know about the game: the cells they
+// can see and what is on them, and their partner while the partner is
+// visible. Servers that hide the map send views instead of actions and
+// snapshots, the client renders them without knowing the level.
+type PlayerView struct {
+	Tick        Tick
+	Width       int
+	Height      int
+	Players     []ViewPlayer // the viewer first, then the partner if visible
+	Cells       []ViewCell   // nil if the same as in the previous view
+	Doors       []ViewDoor
+	Triggers    []ViewTrigger
+	Plates      []ViewPlate
+	BannWalls   []ViewBannWall
+	Boulders    []ViewBoulder
+	Transitions []TransitionSnapshot
+	Blocked     []DenyReason // the viewer's moves resolved to stay since the last view
+}
+
+type ViewPlayer struct {
+	Player  Player
+	Pos     SnapshotPos
+	LooksIn Direction
+}
+
+type ViewCell struct {
+	Pos  SnapshotPos
+	Wall bool
+}
+
+type ViewDoor struct {
+	ID   DoorID
+	Pos  SnapshotPos
+	Open bool
+}
+
+type ViewTrigger struct {
+	ID     TriggerID
+	Pos    SnapshotPos
+	Dir    Direction // the side of the cell the lever is on
+	Active bool
+}
+
+type ViewPlate struct {
+	ID     PlateID
+	Pos    SnapshotPos
+	Active bool
+}
+
+type ViewBannWall struct {
+	ID     BannWallID
+	Pos    SnapshotPos
+	Type   int
+	Active bool
+}
+
+type ViewBoulder struct {
+	ID     BoulderID
+	Pos    SnapshotPos
+	Active bool
+}
+
+// View returns what player may know about the game.
+func (g *Game) View(player Player) *PlayerView {
+	v := &PlayerView{Tick: g.tick, Width: g.Width(), Height: g.Height()}
+
+	v.Cells = make([]ViewCell, 0)
+	for y := 0; y < g.Height(); y++ {
+		for x := 0; x < g.Width(); x++ {
+			pos := NewMapPosition(x, y)
+			if g.PlayerCanSeeCell(player, pos) {
+				v.Cells = append(v.Cells, ViewCell{NewSnapshotPos(pos), g.gameMap.Cell(pos).IsWall()})
+			}
+		}
+	}
+
+	for pos, door := range g.doors {
+		if g.PlayerCanSeeDoor(player, door) {
+			v.Doors = append(v.Doors, ViewDoor{door.id, NewSnapshotPos(pos), door.isOpen})
+		}
+	}
+	sort.Slice(v.Doors, func(i, j int) bool { return v.Doors[i].ID < v.Doors[j].ID })
+
+	for pos, trigger := range g.triggers {
+		if !g.PlayerCanSeeTrigger(player, trigger) {
+			continue
+		}
+		if dir, err := g.gameMap.Cell(pos).DirOfTrigger(trigger); err == nil {
+			v.Triggers = append(v.Triggers, ViewTrigger{trigger.id, NewSnapshotPos(pos), dir, trigger.isActive})
+		}
+	}
+	sort.Slice(v.Triggers, func(i, j int) bool { return v.Triggers[i].ID < v.Triggers[j].ID })
+
+	for pos, plate := range g.plates {
+		if g.PlayerCanSeePlate(player, plate) {
+			v.Plates = append(v.Plates, ViewPlate{plate.id, NewSnapshotPos(pos), plate.isActive})
+		}
+	}
+	sort.Slice(v.Plates, func(i, j int) bool { return v.Plates[i].ID < v.Plates[j].ID })
+
+	for pos, bannWall := range g.bannWalls {
+		if g.PlayerCanSeeBannWall(player, bannWall) {
+			v.BannWalls = append(v.BannWalls, ViewBannWall{bannWall.id, NewSnapshotPos(pos), bannWall.bannWallType, bannWall.isActive})
+		}
+	}
+	sort.Slice(v.BannWalls, func(i, j int) bool { return v.BannWalls[i].ID < v.BannWalls[j].ID })
+
+	visible := make(map[*Boulder]bool)
+	for pos, boulder := range g.boulders {
+		if g.PlayerCanSeeBoulder(player, boulder) {
+			visible[boulder] = true
+			v.Boulders = append(v.Boulders, ViewBoulder{boulder.id, NewSnapshotPos(pos), boulder.active})
+		}
+	}
+	sort.Slice(v.Boulders, func(i, j int) bool { return v.Boulders[i].ID < v.Boulders[j].ID })
+
+	// the partner's transitions give away where they are
+	shown := map[Player]bool{player: true}
+	v.Players = append(v.Players, g.viewPlayer(player))
+	for _, other := range g.players {
+		if other != player && g.PlayerCanSeeOtherPlayer(player) {
+			shown[other] = true
+			v.Players = append(v.Players, g.viewPlayer(other))
+		}
+	}
+
+	for _, ts := range g.snapshotTransitions() {
+		switch ts.Kind {
+		case TransitionBoulder:
+			if visible[g.boulderById(ts.Boulder)] {
+				v.Transitions = append(v.Transitions, ts)
+			}
+		case TransitionPlayerMove, TransitionPlayerAction:
+			if shown[ts.Player] {
+				v.Transitions = append(v.Transitions, ts)
+			}
+		default:
+			if ts.Player == player {
+				v.Transitions = append(v.Transitions, ts)
+			}
+		}
+	}
+
+	return v
+}
+
+func (g *Game) viewPlayer(player Player) ViewPlayer {
+	state := g.playerState[player]
+	return ViewPlayer{player, NewSnapshotPos(state.mapPos), state.looksIn}
+}
+
+// NewViewGame builds a game that holds nothing but the view. It is only good
+// for rendering, actions cannot be performed on it.
+func NewViewGame(view *PlayerView) *Game {
+	g := newGame(view.Width, view.Height)
+	g.ApplyView(view)
+	return g
+}
+
+// ApplyView replaces what the game holds with the view. The cells are kept if
+// the view leaves them out.
+func (g *Game) ApplyView(view *PlayerView) {
+	viewer := view.Players[0].Player
+
+	vis := NewPlayerVis()
+	if old, ok := g.playerVis[viewer]; ok && view.Cells == nil {
+		vis.visCell = old.visCell
+	} else {
+		for y := 0; y < g.Height(); y++ {
+			for x := 0; x < g.Width(); x++ {
+				g.gameMap.cells[y][x].isWall = false
+			}
+		}
+		for _, vc := range view.Cells {
+			pos := vc.Pos.MapPosition()
+			vis.visCell[pos] = true
+			g.gameMap.Cell(pos).isWall = vc.Wall
+		}
+	}
+
+	g.doors = make(map[MapPosition]*Door)
+	for _, vd := range view.Doors {
+		door := NewDoor()
+		door.id = vd.ID
+		door.isOpen = vd.Open
+		g.doors[vd.Pos.MapPosition()] = door
+		vis.visDoor[door] = true
+	}
+
+	for y := 0; y < g.Height(); y++ {
+		for x := 0; x < g.Width(); x++ {
+			g.gameMap.cells[y][x].accessibleTriggers = make(map[Direction]*Trigger)
+		}
+	}
+	g.triggers = make(map[MapPosition]*Trigger)
+	for _, vt := range view.Triggers {
+		trigger := g.SetTrigger(vt.Pos.MapPosition(), vt.Dir, viewer, viewer, nil)
+		trigger.id = vt.ID
+		trigger.isActive = vt.Active
+		vis.visTrigger[trigger] = true
+	}
+
+	g.plates = make(map[MapPosition]*Plate)
+	for _, vp := range view.Plates {
+		plate := NewPlate()
+		plate.id = vp.ID
+		plate.isActive = vp.Active
+		g.plates[vp.Pos.MapPosition()] = plate
+		vis.visPlate[plate] = true
+	}
+
+	g.bannWalls = make(map[MapPosition]*BannWall)
+	for _, vb := range view.BannWalls {
+		bannWall := NewBannWall(vb.Type)
+		bannWall.id = vb.ID
+		bannWall.isActive = vb.Active
+		g.bannWalls[vb.Pos.MapPosition()] = bannWall
+		vis.visBannWall[bannWall] = true
+	}
+
+	g.boulders = make(map[MapPosition]*Boulder)
+	for _, vb := range view.Boulders {
+		boulder := NewBoulder(vb.Active)
+		boulder.id = vb.ID
+		g.boulders[vb.Pos.MapPosition()] = boulder
+		vis.visBoulder[boulder] = true
+	}
+
+	g.players = make([]Player, 0, 2)
+	g.playerState = make(map[Player]*PlayerState, 2)
+	for _, vp := range view.Players {
+		g.players = append(g.players, vp.Player)
+		g.playerState[vp.Player] = NewPlayerState(vp.Pos.MapPosition(), vp.LooksIn)
+	}
+	vis.visPlayer = len(view.Players) > 1
+	g.playerVis = map[Player]*PlayerVis{viewer: vis}
+	g.playerCans = map[Player]*PlayerCans{viewer: NewPlayerCans()}
+
+	if err := g.restoreTransitions(view.Transitions); err != nil {
+		gameLog.Warn("Failed to restore transitions of the view", "err", err)
+	}
+	g.tick = view.Tick
+}
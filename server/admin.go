@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"laby/game"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The admin API and console let whoever runs the server look into the
+// session and step in. The API only listens on the loopback interface.
+
+var startTime = time.Now()
+
+type AdminPlayer struct {
+	Nick      string
+	Role      string
+	Player    game.Player
+	Bot       bool
+	Connected bool
+	Address   string
+	RTT       string
+	Idle      string // since the last request
+}
+
+type AdminSession struct {
+	Level      string
+	Started    bool
+	Paused     bool
+	Tick       game.Tick
+	Players    []AdminPlayer
+	Spectators int
+	Recording  bool
+}
+
+type AdminStatus struct {
+	Name     string
+	Uptime   string
+	Levels   []string // levels the server can play
+	Sessions []AdminSession
+}
+
+// Status describes the server. There is a single session for now.
+func Status(cfg *Config) AdminStatus {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	session := AdminSession{
+		Level:      gameState.game.LevelId(),
+		Started:    gameState.gameStarted,
+		Paused:     gameState.paused,
+		Tick:       gameState.game.Tick(),
+		Players:    make([]AdminPlayer, 0, len(gameState.playerData)),
+		Spectators: len(gameState.spectators),
+		Recording:  gameState.replay != nil,
+	}
+
+	for player, _ := range gameState.playerData {
+		info := AdminPlayer{
+			Nick:      player.nick,
+			Role:      game.RoleName(player.gamePlayer),
+			Player:    player.gamePlayer,
+			Bot:       player.bot != nil,
+			Connected: player.connected,
+			RTT:       player.rtt.String(),
+			Idle:      time.Since(player.lastSeen).Truncate(time.Millisecond).String(),
+		}
+		if player.conn != nil {
+			info.Address = player.conn.RemoteAddr().String()
+		}
+		session.Players = append(session.Players, info)
+	}
+	sort.Slice(session.Players, func(i, j int) bool {
+		return session.Players[i].Player < session.Players[j].Player
+	})
+
+	return AdminStatus{
+		Name:     cfg.Name,
+		Uptime:   time.Since(startTime).Truncate(time.Second).String(),
+		Levels:   []string{gameState.game.LevelId()},
+		Sessions: []AdminSession{session},
+	}
+}
+
+// KickPlayer drops the player with the nickname from the session, the
+// partner is back in the lobby. A connected player is removed by its own
+// connection once that noticed the close, it may still be in the middle of
+// a request.
+func KickPlayer(nick string) error {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	for player, _ := range gameState.playerData {
+		if !strings.EqualFold(player.nick, nick) || player.kicked {
+			continue
+		}
+
+		playerLog(sessionLog, player).Info("Admin kicked player")
+		if player.conn == nil || !player.connected {
+			// a bot or a player waiting for the reconnect, nobody else
+			// uses it
+			removeKicked(player)
+		} else {
+			player.kicked = true
+			player.conn.Close()
+		}
+		return nil
+	}
+
+	return errors.New("No player " + nick)
+}
+
+// removeKicked takes the kicked player out of the session. The caller holds
+// the data lock.
+func removeKicked(player *Player) {
+	if player.reconnectTimer != nil {
+		player.reconnectTimer.Stop()
+		player.reconnectTimer = nil
+	}
+	auditPlayer(player, game.AuditEvent{Tick: gameState.game.Tick(), Kind: game.AuditLeave, Reason: "kicked"})
+	delete(gameState.playerData, player)
+	if len(gameState.playerData) == 0 {
+		gameState.resume = nil
+	}
+	resetSession()
+}
+
+// ForceRestart restarts the level without asking the players.
+func ForceRestart() error {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	if !gameState.gameStarted {
+		return errors.New("Game is not running")
+	}
+
+	gameState.vote = nil
+	return restartLevel()
+}
+
+// ChangeLevel switches the session to another level. The level is built
+// into the game, so only the one that is loaded can be chosen, which
+// restarts it.
+func ChangeLevel(level string) error {
+	gameState.dataLock.Lock()
+	levelId := gameState.game.LevelId()
+	gameState.dataLock.Unlock()
+
+	if level != levelId {
+		return errors.New("Unknown level " + level)
+	}
+	return ForceRestart()
+}
+
+// GameStateJSON dumps the state of the session's game.
+func GameStateJSON() ([]byte, error) {
+	snapshot, _ := GameSnapshot()
+	return json.MarshalIndent(snapshot, "", "  ")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		netLog.Warn("Failed to write admin response", "err", err)
+	}
+}
+
+// adminToken guards every request to the admin API. A web page the operator
+// opens can reach the loopback interface too, but it cannot set the header.
+func adminToken(token string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), []byte(token)) != 1 {
+			http.Error(w, "Missing or wrong X-Admin-Token", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// adminAction wraps a handler that changes the session, it only accepts POST.
+func adminAction(action func(r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Use POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := action(r); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, "ok")
+	}
+}
+
+// ServeAdmin runs the admin HTTP API, every request needs the token in the
+// X-Admin-Token header:
+//
+//	GET  /status             sessions, players, roles, levels and uptime
+//	GET  /state              the game state as JSON
+//	POST /kick?nick=<nick>   drop a player
+//	POST /restart            restart the level
+//	POST /level?id=<level>   change the level
+func ServeAdmin(addr, token string, cfg *Config) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, Status(cfg))
+	})
+	mux.HandleFunc("/state", func(w http.ResponseWriter, r *http.Request) {
+		state, err := GameStateJSON()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(state)
+	})
+	mux.Handle("/kick", adminAction(func(r *http.Request) error {
+		return KickPlayer(r.FormValue("nick"))
+	}))
+	mux.Handle("/restart", adminAction(func(r *http.Request) error {
+		return ForceRestart()
+	}))
+	mux.Handle("/level", adminAction(func(r *http.Request) error {
+		return ChangeLevel(r.FormValue("id"))
+	}))
+	return http.ListenAndServe(addr, adminToken(token, mux))
+}
+
+const consoleHelp = `Commands:
+  status          sessions, players, roles, levels and uptime
+  state           the game state as JSON
+  kick <nick>     drop a player
+  restart         restart the level
+  level <level>   change the level
+  help            this text`
+
+func printStatus(out io.Writer, status AdminStatus) {
+	fmt.Fprintf(out, "%s up %s, levels %s\n", status.Name, status.Uptime,
+		strings.Join(status.Levels, ", "))
+
+	for i, session := range status.Sessions {
+		state := "lobby"
+		if session.Paused {
+			state = "paused"
+		} else if session.Started {
+			state = "running"
+		}
+		fmt.Fprintf(out, "session %d: level %s, %s at tick %d, %d spectators\n",
+			i+1, session.Level, state, session.Tick, session.Spectators)
+
+		for _, player := range session.Players {
+			switch {
+			case player.Bot:
+				fmt.Fprintf(out, "  %s (%s) bot\n", player.Nick, player.Role)
+			case player.Connected:
+				fmt.Fprintf(out, "  %s (%s) %s rtt %s idle %s\n", player.Nick, player.Role,
+					player.Address, player.RTT, player.Idle)
+			default:
+				fmt.Fprintf(out, "  %s (%s) disconnected for %s\n", player.Nick, player.Role,
+					player.Idle)
+			}
+		}
+	}
+}
+
+// RunConsole reads admin commands line by line until the input ends.
+func RunConsole(in io.Reader, out io.Writer, cfg *Config) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		args := strings.Fields(scanner.Text())
+		if len(args) == 0 {
+			continue
+		}
+
+		var err error
+		switch args[0] {
+		case "status":
+			printStatus(out, Status(cfg))
+		case "state":
+			var state []byte
+			if state, err = GameStateJSON(); err == nil {
+				fmt.Fprintln(out, string(state))
+			}
+		case "kick":
+			if len(args) < 2 {
+				err = errors.New("Usage: kick <nick>")
+			} else {
+				err = KickPlayer(strings.Join(args[1:], " "))
+			}
+		case "restart":
+			err = ForceRestart()
+		case "level":
+			if len(args) != 2 {
+				err = errors.New("Usage: level <level>")
+			} else {
+				err = ChangeLevel(args[1])
+			}
+		case "help":
+			fmt.Fprintln(out, consoleHelp)
+		default:
+			err = errors.New("Unknown command " + args[0] + ", try help")
+		}
+
+		if err != nil {
+			fmt.Fprintln(out, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"laby/game"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The audit log has one JSON line per gameplay event, for studying how
+// levels are solved and for looking into bug reports. `audit` reads it.
+
+const (
+	auditFile    = "audit.jsonl"
+	maxAuditSize = 16 * 1024 * 1024 // the file is rotated after this many bytes
+)
+
+var auditLog *AuditLog // nil when disabled
+
+type AuditLog struct {
+	lock sync.Mutex
+	dir  string
+	file *os.File
+	size int64
+}
+
+// OpenAuditLog appends to the audit log in dir.
+func OpenAuditLog(dir string) (*AuditLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	a := &AuditLog{dir: dir}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AuditLog) open() error {
+	file, err := os.OpenFile(filepath.Join(a.dir, auditFile), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	a.file = file
+	a.size = info.Size()
+
+	// a line cut off by a crash must not swallow the next one
+	if a.size > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, a.size-1); err == nil && last[0] != '\n' {
+			n, _ := io.WriteString(file, "\n")
+			a.size += int64(n)
+		}
+	}
+	return nil
+}
+
+// rotate moves the full log aside and starts a new one.
+func (a *AuditLog) rotate() error {
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+
+	rotated := filepath.Join(a.dir, "audit-"+time.Now().Format("20060102-150405")+".jsonl")
+	if err := os.Rename(filepath.Join(a.dir, auditFile), rotated); err != nil {
+		return err
+	}
+	return a.open()
+}
+
+func (a *AuditLog) Write(event game.AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.size > 0 && a.size+int64(len(line)) > maxAuditSize {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	return err
+}
+
+// audit adds the event to the audit log with the time and the running
+// session.
+func audit(event game.AuditEvent) {
+	if auditLog == nil {
+		return
+	}
+
+	event.Time = time.Now()
+	event.Session = sessionId()
+	if err := auditLog.Write(event); err != nil {
+		sessionLog.Error("Failed to write audit log", "err", err)
+	}
+}
+
+// auditPlayer fills in who the event is about.
+func auditPlayer(player *Player, event game.AuditEvent) {
+	gamePlayer := player.gamePlayer
+	event.Player = &gamePlayer
+	event.Nick = player.nick
+	audit(event)
+}
+
+// auditRoles writes who plays which role in the session that just started.
+// The caller holds the data lock.
+func auditRoles() {
+	for player, _ := range gameState.playerData {
+		event := game.AuditEvent{Tick: gameState.game.Tick(), Kind: game.AuditRole}
+		if player.bot != nil {
+			event.Reason = "bot"
+		}
+		auditPlayer(player, event)
+	}
+}
+
+// auditGameEvents writes the changes to the level taken from the game. The
+// caller holds the data lock.
+func auditGameEvents(events []game.GameEvent) {
+	for _, e := range events {
+		event := game.AuditEvent{Tick: e.Tick, Kind: e.Kind, Id: e.Id, Active: e.Active}
+		if e.Kind == game.EventTrigger || e.Kind == game.EventBlocked {
+			player := e.Player
+			event.Player = &player
+		}
+		audit(event)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"laby/game"
+	"time"
+)
+
+// With -bot a bot takes the second role as soon as somebody joins, for
+// playing alone.
+var botEnabled = false
+
+const botInterval = 100 * time.Millisecond
+
+// addBot fills the free role with a bot. The caller holds the data lock.
+func addBot() {
+	playerId := freePlayerId()
+	if playerId < 0 {
+		return
+	}
+
+	gamePlayer := gameState.game.NewPlayer(playerId)
+	bot := NewPlayer(nil, gamePlayer, uniqueNick("Bot", playerId), "")
+	bot.bot = game.NewBot(gamePlayer)
+
+	gameState.playerData[bot] = NewPlayerState()
+	playerLog(sessionLog, bot).Info("Bot joined")
+}
+
+// resetBots makes the bots forget what they knew about the old state. The
+// caller holds the data lock.
+func resetBots() {
+	for player, _ := range gameState.playerData {
+		if player.bot != nil {
+			player.bot = game.NewBot(player.gamePlayer)
+		}
+	}
+}
+
+// RunBots lets the bots play. A bot sees the server's game directly, it acts
+// like a client that fetches an update and sends at most one action each
+// time.
+func RunBots() {
+	for {
+		time.Sleep(botInterval)
+		stepBots()
+	}
+}
+
+func stepBots() {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	if !gameState.gameStarted || gameState.paused {
+		return
+	}
+
+	for player, state := range gameState.playerData {
+		if player.bot == nil {
+			continue
+		}
+		player.lastSeen = time.Now()
+
+		// the bot has seen its partner's actions
+		for other, otherState := range gameState.playerData {
+			if other != player {
+				otherState.newActions = make([]game.TickAction, 0)
+			}
+		}
+
+		for _, msg := range state.inbox {
+			player.bot.Hear(gameState.game, msg)
+		}
+		state.inbox = make([]game.Message, 0)
+
+		// like a client, the bot waits until its partner saw its last action
+		if len(state.newActions) == 0 {
+			if action, ok := player.bot.NextAction(gameState.game); ok {
+				if _, err := performPlayerActions(player, []game.ActionType{action}); err != nil {
+					playerLog(gameLog, player).Debug("Bot action failed", "action", action, "err", err)
+				}
+			}
+		}
+
+		for _, msg := range player.bot.TakeMessages() {
+			if err := postMessage(player, msg); err != nil {
+				playerLog(gameLog, player).Debug("Bot message dropped", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"laby/game"
+	"time"
+)
+
+// Messages wait in the inbox of every recipient until the client polls them,
+// the oldest ones are dropped once an inbox is full.
+const maxInbox = 32
+
+const (
+	chatBurst      = 5
+	chatInterval   = 2 * time.Second
+	markerBurst    = 3
+	markerInterval = 1 * time.Second
+)
+
+func deliver(inbox []game.Message, msg game.Message) []game.Message {
+	inbox = append(inbox, msg)
+	if len(inbox) > maxInbox {
+		inbox = inbox[len(inbox)-maxInbox:]
+	}
+	return inbox
+}
+
+// PostMessage validates a chat line or map ping from player and hands it to
+// everybody in the session, including the sender.
+func PostMessage(player *Player, msg game.Message) error {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	return postMessage(player, msg)
+}
+
+// postMessage is PostMessage for callers that hold the data lock.
+func postMessage(player *Player, msg game.Message) error {
+	state := gameState.playerData[player]
+
+	switch msg.Kind {
+	case game.MessageChat:
+		text, err := game.CleanChatText(msg.Text)
+		if err != nil {
+			return err
+		}
+		if !state.chatLimit.Allow() {
+			return errors.New("Chatting too fast")
+		}
+		msg.Text = text
+		msg.X, msg.Y = 0, 0
+	case game.MessageMarker:
+		if msg.X < 0 || msg.Y < 0 || msg.X >= gameState.game.Width() || msg.Y >= gameState.game.Height() {
+			return errors.New("Marker outside of the map")
+		}
+		if !state.markerLimit.Allow() {
+			return errors.New("Placing markers too fast")
+		}
+		msg.Text = ""
+	default:
+		return errors.New("Unknown message kind")
+	}
+
+	msg.From = player.gamePlayer
+	msg.Nick = player.nick
+
+	for _, other := range gameState.playerData {
+		other.inbox = deliver(other.inbox, msg)
+	}
+	for _, spectator := range gameState.spectators {
+		spectator.inbox = deliver(spectator.inbox, msg)
+	}
+
+	return nil
+}
+
+func TakeMessages(player *Player) []game.Message {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	state := gameState.playerData[player]
+	messages := state.inbox
+	state.inbox = make([]game.Message, 0)
+	return messages
+}
+
+func TakeSpectatorMessages(conn game.Transport) []game.Message {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	spectator := gameState.spectators[conn]
+	messages := spectator.inbox
+	spectator.inbox = make([]game.Message, 0)
+	return messages
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Addr             string
+	Port             int
+	Name             string
+	Discovery        bool
+	DiscoveryPort    int
+	WebSocketPort    int      // 0 disables the WebSocket listener
+	WebSocketOrigins []string // web pages that may open a WebSocket session, e.g. "https://laby.example"
+	Timeout          string   // drop peers that stay silent for this long
+	HealthLog        string   // interval of the network health log
+	SaveDir          string   // saved sessions are kept here
+	ReplayDir        string   // sessions are recorded here, empty disables it
+	Bot              bool     // a bot takes the second role
+	AdminPort        int      // local port of the admin API, 0 disables it
+	AdminToken       string   // the admin API wants it in every request, made up at start if empty
+	Console          bool     // read admin commands from stdin
+	MetricsPort      int      // local port of the Prometheus metrics, 0 disables them
+	LogLevel         string   // e.g. "info" or "warn,net=debug"
+	AuditDir         string   // gameplay events are logged here, empty disables it
+	Fog              bool     // players only learn what they can see
+	Level            string   // the level file
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Addr:             "",
+		Port:             8001,
+		Name:             "laby",
+		Discovery:        true,
+		DiscoveryPort:    8002,
+		WebSocketPort:    0,
+		WebSocketOrigins: nil,
+		Timeout:          "10s",
+		HealthLog:        "30s",
+		SaveDir:          "saves",
+		ReplayDir:        "replays",
+		Bot:              false,
+		AdminPort:        0,
+		AdminToken:       "",
+		Console:          false,
+		MetricsPort:      0,
+		LogLevel:         "info",
+		AuditDir:         "audits",
+		Fog:              false,
+		Level:            "levels/gamejam-1.json",
+	}
+}
+
+func (cfg *Config) ListenAddr() string {
+	return net.JoinHostPort(cfg.Addr, strconv.Itoa(cfg.Port))
+}
+
+func (cfg *Config) WebSocketAddr() string {
+	return net.JoinHostPort(cfg.Addr, strconv.Itoa(cfg.WebSocketPort))
+}
+
+// AdminAddr only binds the loopback interface.
+func (cfg *Config) AdminAddr() string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.AdminPort))
+}
+
+func (cfg *Config) MetricsAddr() string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.MetricsPort))
+}
+
+func (cfg *Config) PeerTimeout() time.Duration {
+	d, _ := time.ParseDuration(cfg.Timeout)
+	return d
+}
+
+func (cfg *Config) HealthLogInterval() time.Duration {
+	d, _ := time.ParseDuration(cfg.HealthLog)
+	return d
+}
+
+func LoadConfig(path string, cfg *Config) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewDecoder(file).Decode(cfg)
+}
+
+// ParseConfig reads the config file and lets command line flags override it.
+func ParseConfig() (*Config, error) {
+	cfg := DefaultConfig()
+	defaults := DefaultConfig()
+
+	configPath := flag.String("config", "server.json", "config file")
+	addr := flag.String("addr", defaults.Addr, "address to listen on")
+	port := flag.Int("port", defaults.Port, "port to listen on")
+	name := flag.String("name", defaults.Name, "server name shown to discovering clients")
+	discovery := flag.Bool("discovery", defaults.Discovery, "answer LAN discovery requests")
+	discoveryPort := flag.Int("discovery-port", defaults.DiscoveryPort, "UDP port for LAN discovery")
+	webSocketPort := flag.Int("ws-port", defaults.WebSocketPort, "port for WebSocket clients, 0 disables them")
+	webSocketOrigins := flag.String("ws-origins", "", "comma separated origins of the web pages that may open WebSocket sessions")
+	timeout := flag.String("timeout", defaults.Timeout, "disconnect peers that stay silent for this long")
+	healthLog := flag.String("health-log", defaults.HealthLog, "interval of the network health log")
+	saveDir := flag.String("save-dir", defaults.SaveDir, "directory for saved sessions")
+	replayDir := flag.String("replay-dir", defaults.ReplayDir, "directory for session replays, empty disables recording")
+	bot := flag.Bool("bot", defaults.Bot, "let a bot take the second role for solo play")
+	adminPort := flag.Int("admin-port", defaults.AdminPort, "local port of the admin HTTP API, 0 disables it")
+	adminToken := flag.String("admin-token", defaults.AdminToken, "token the admin API wants in the X-Admin-Token header, random if empty")
+	console := flag.Bool("console", defaults.Console, "read admin commands from stdin")
+	metricsPort := flag.Int("metrics-port", defaults.MetricsPort, "local port of the Prometheus metrics, 0 disables them")
+	logLevel := flag.String("log-level", defaults.LogLevel, "log level, optionally per component, e.g. warn,net=debug")
+	auditDir := flag.String("audit-dir", defaults.AuditDir, "directory for the gameplay audit log, empty disables it")
+	fog := flag.Bool("fog", defaults.Fog, "send players only what they can see, spectators are refused")
+	level := flag.String("level", defaults.Level, "level file")
+	flag.Parse()
+
+	configSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			configSet = true
+		}
+	})
+
+	if err := LoadConfig(*configPath, cfg); err != nil {
+		if configSet || !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Addr = *addr
+		case "port":
+			cfg.Port = *port
+		case "name":
+			cfg.Name = *name
+		case "discovery":
+			cfg.Discovery = *discovery
+		case "discovery-port":
+			cfg.DiscoveryPort = *discoveryPort
+		case "ws-port":
+			cfg.WebSocketPort = *webSocketPort
+		case "ws-origins":
+			cfg.WebSocketOrigins = strings.Split(*webSocketOrigins, ",")
+		case "timeout":
+			cfg.Timeout = *timeout
+		case "health-log":
+			cfg.HealthLog = *healthLog
+		case "save-dir":
+			cfg.SaveDir = *saveDir
+		case "replay-dir":
+			cfg.ReplayDir = *replayDir
+		case "bot":
+			cfg.Bot = *bot
+		case "admin-port":
+			cfg.AdminPort = *adminPort
+		case "admin-token":
+			cfg.AdminToken = *adminToken
+		case "console":
+			cfg.Console = *console
+		case "metrics-port":
+			cfg.MetricsPort = *metricsPort
+		case "log-level":
+			cfg.LogLevel = *logLevel
+		case "audit-dir":
+			cfg.AuditDir = *auditDir
+		case "fog":
+			cfg.Fog = *fog
+		case "level":
+			cfg.Level = *level
+		}
+	})
+
+	if d, err := time.ParseDuration(cfg.Timeout); err != nil || d <= 0 {
+		return nil, errors.New("Invalid timeout " + cfg.Timeout)
+	}
+
+	if d, err := time.ParseDuration(cfg.HealthLog); err != nil || d <= 0 {
+		return nil, errors.New("Invalid health log interval " + cfg.HealthLog)
+	}
+
+	return cfg, nil
+}
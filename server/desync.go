@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"laby/game"
+)
+
+// CheckStateHash compares the client's hash with the one the server recorded
+// for the same tick. A client that drifted gets a snapshot of the current
+// state to resync from, nil is returned for a client that agrees.
+func CheckStateHash(player *Player, stateHash game.StateHash) *game.GameSnapshot {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	hash, ok := gameState.game.HashAt(stateHash.Tick)
+	if !ok {
+		playerLog(gameLog, player).Debug("No state hash recorded", "tick", stateHash.Tick)
+		return nil
+	}
+
+	if stateHash.Hash == hash {
+		return nil
+	}
+
+	playerLog(gameLog, player).Warn("Desync, sending resync", "tick", stateHash.Tick,
+		"server", fmt.Sprintf("%016x", hash), "client", fmt.Sprintf("%016x", stateHash.Hash))
+	return gameState.game.Snapshot()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"laby/game"
+)
+
+// With the fog on, players get views of what they can see instead of their
+// partner's actions. Snapshots, updates and hash checks would give the rest
+// of the map away, so they are not answered.
+
+var fogEnabled = false
+
+// joinLevel is the level as joining players get it, nothing but its outline
+// if the map is hidden.
+func joinLevel() *game.Level {
+	if fogEnabled {
+		return game.CurrentLevel().Outline()
+	}
+	return game.CurrentLevel()
+}
+
+// fogHides tells whether answering the request would tell a player more than
+// they can see.
+func fogHides(req game.ClientRequest) bool {
+	if !fogEnabled {
+		return false
+	}
+
+	switch req {
+	case game.ClientReqUpdate, game.ClientReqSnapshot, game.ClientReqHash:
+		return true
+	}
+	return false
+}
+
+// PlayerView returns what the player may know about the game. The cells are
+// left out if the player got the same ones with the last view.
+func PlayerView(player *Player) *game.PlayerView {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	view := gameState.game.View(player.gamePlayer)
+	state := gameState.playerData[player]
+	if sameCells(state.viewCells, view.Cells) {
+		view.Cells = nil
+	} else {
+		state.viewCells = view.Cells
+	}
+	view.Blocked = state.blocked
+	state.blocked = nil
+	return view
+}
+
+// noteBlocked keeps the moves that were resolved to stay for the players'
+// next views. With the fog on the clients do not simulate, so they cannot
+// find out themselves. The caller holds the data lock.
+func noteBlocked(events []game.GameEvent) {
+	if !fogEnabled {
+		return
+	}
+
+	for _, e := range events {
+		if e.Kind != game.EventBlocked {
+			continue
+		}
+		for player, state := range gameState.playerData {
+			if player.gamePlayer == e.Player {
+				state.blocked = append(state.blocked, game.DenyReason(e.Id))
+			}
+		}
+	}
+}
+
+func sameCells(a, b []game.ViewCell) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, _ := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+func NetworkHealth() string {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	parts := make([]string, 0, len(gameState.playerData)+1)
+	for player, _ := range gameState.playerData {
+		if player.connected {
+			parts = append(parts, fmt.Sprintf("%v rtt %v idle %v",
+				player, player.rtt, time.Since(player.lastSeen)))
+		} else {
+			parts = append(parts, fmt.Sprintf("%v disconnected for %v",
+				player, time.Since(player.lastSeen)))
+		}
+	}
+	parts = append(parts, fmt.Sprintf("%d spectators", len(gameState.spectators)))
+
+	return strings.Join(parts, ", ")
+}
+
+func LogNetworkHealth(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		inSession(netLog).Info("Network health", "peers", NetworkHealth())
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"laby/game"
+)
+
+// Clients are not trusted. Values outside of the protocol get a client
+// disconnected, and so does acting much faster than the game allows.
+
+const (
+	maxClientMessage  = 16 * 1024 // bytes of one decoded value
+	maxRequestActions = 1         // the answer to a request is for a single action
+
+	moveBurst  = 3 // moves or actions that arrive together because of jitter
+	lookBurst  = 10
+	maxStrikes = 50 // actions in a row that were too fast
+)
+
+// ActionLimits keeps a player to one move per walk time, one lever or boulder
+// action per action time and one look per tick. It is not safe for concurrent
+// use.
+type ActionLimits struct {
+	move    *RateLimiter
+	act     *RateLimiter
+	look    *RateLimiter
+	strikes int
+}
+
+func NewActionLimits(g *game.Game) *ActionLimits {
+	return &ActionLimits{
+		move: NewRateLimiter(moveBurst, g.WalkTime()),
+		act:  NewRateLimiter(moveBurst, g.ActionTime()),
+		look: NewRateLimiter(lookBurst, game.TickDuration),
+	}
+}
+
+func (al *ActionLimits) limit(action game.ActionType) *RateLimiter {
+	switch action {
+	case game.ActionMoveNorth, game.ActionMoveWest, game.ActionMoveSouth, game.ActionMoveEast:
+		return al.move
+	case game.ActionAction, game.ActionToggleVisibility:
+		return al.act
+	}
+	return al.look
+}
+
+// Allow tells whether the action may be performed now. The action is charged
+// for until the game denies it, see Refund.
+func (al *ActionLimits) Allow(action game.ActionType) bool {
+	if !al.limit(action).Allow() {
+		al.strikes++
+		return false
+	}
+	al.strikes = 0
+	return true
+}
+
+// Refund gives back what an action the game denied was charged, so bumping
+// into a wall does not use up the player's next move.
+func (al *ActionLimits) Refund(action game.ActionType) {
+	al.limit(action).Refund()
+}
+
+// Flooding tells whether the player keeps sending actions too fast.
+func (al *ActionLimits) Flooding() bool {
+	return al.strikes >= maxStrikes
+}
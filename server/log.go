@@ -0,0 +1,47 @@
+package main
+
+import (
+	"laby/game"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	gameLog    = game.NewLogger(game.LogGame)
+	netLog     = game.NewLogger(game.LogNet)
+	sessionLog = game.NewLogger(game.LogSession)
+)
+
+// currentSession names the running session in the log, it is empty while
+// the players wait in the lobby.
+var currentSession atomic.Value
+
+// startSession gives the session that just started its name, the replay is
+// named after it too.
+func startSession() string {
+	id := time.Now().Format("20060102-150405")
+	currentSession.Store(id)
+	return id
+}
+
+func endSession() {
+	currentSession.Store("")
+}
+
+func sessionId() string {
+	id, _ := currentSession.Load().(string)
+	return id
+}
+
+// inSession adds the running session to the lines of logger.
+func inSession(logger *game.Logger) *game.Logger {
+	if id := sessionId(); id != "" {
+		return logger.With("session", id)
+	}
+	return logger
+}
+
+// playerLog adds the session and the player to the lines of logger.
+func playerLog(logger *game.Logger, player *Player) *game.Logger {
+	return inSession(logger).With("player", player.nick, "role", game.RoleName(player.gamePlayer))
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"laby/game"
+	"os"
+	"testing"
+	"time"
+)
+
+// The loopback tests run a session with scripted clients in one process, the
+// server's game is stepped by the test instead of UpdateGame.
+
+func TestMain(m *testing.M) {
+	level, err := game.ReadLevelFile("../levels/gamejam-1.json")
+	if err == nil {
+		err = game.LoadLevel(level)
+	}
+	if err != nil {
+		sessionLog.Error("Failed to load level", "err", err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// testClient speaks the client's side of the protocol.
+type testClient struct {
+	t      *testing.T
+	conn   game.Transport
+	player game.Player
+	nick   string
+}
+
+func joinLoopback(t *testing.T, nick string) *testClient {
+	conn := ConnectLoopback()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.Encode(game.JoinRequest{Nick: nick}); err != nil {
+		t.Fatal(err)
+	}
+	var resp game.JoinResponse
+	if err := conn.Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Accepted {
+		t.Fatal("Join refused: " + resp.Reason)
+	}
+	if resp.Level == nil || resp.Level.Id != game.CurrentLevel().Id {
+		t.Fatal("Join sent no level")
+	}
+	return &testClient{t: t, conn: conn, player: resp.Player, nick: resp.Nick}
+}
+
+func (c *testClient) send(action game.ActionType) (game.ServerResponse, game.Tick, game.DenyReason) {
+	var resp game.ServerResponse
+	var tick game.Tick
+	var reason game.DenyReason
+
+	for _, v := range []interface{}{game.ClientReqSendAction, 1, action} {
+		if err := c.conn.Encode(v); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	if err := c.conn.Decode(&resp); err != nil {
+		c.t.Fatal(err)
+	}
+	var err error
+	switch resp {
+	case game.ServerActionOk:
+		err = c.conn.Decode(&tick)
+	case game.ServerActionDenied:
+		err = c.conn.Decode(&reason)
+	}
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return resp, tick, reason
+}
+
+// update fetches the partner's actions, it lets the partner act again.
+func (c *testClient) update() []game.TickAction {
+	var numPlayers, numActions int
+	var other game.Player
+	var status game.SessionStatus
+	var tick, hashTick game.Tick
+
+	if err := c.conn.Encode(game.ClientReqUpdate); err != nil {
+		c.t.Fatal(err)
+	}
+	if err := c.conn.Decode(&numPlayers); err != nil {
+		c.t.Fatal(err)
+	}
+	actions := make([]game.TickAction, 0)
+	for i := 0; i < numPlayers; i++ {
+		if err := c.conn.Decode(&other); err != nil {
+			c.t.Fatal(err)
+		}
+		if err := c.conn.Decode(&numActions); err != nil {
+			c.t.Fatal(err)
+		}
+		for j := 0; j < numActions; j++ {
+			var action game.TickAction
+			if err := c.conn.Decode(&action); err != nil {
+				c.t.Fatal(err)
+			}
+			actions = append(actions, action)
+		}
+	}
+	for _, v := range []interface{}{&status, &tick, &hashTick} {
+		if err := c.conn.Decode(v); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	return actions
+}
+
+// players lists the nicks the server knows about.
+func (c *testClient) players() []game.PlayerInfo {
+	var infos []game.PlayerInfo
+	if err := c.conn.Encode(game.ClientReqPlayers); err != nil {
+		c.t.Fatal(err)
+	}
+	if err := c.conn.Decode(&infos); err != nil {
+		c.t.Fatal(err)
+	}
+	return infos
+}
+
+// startLoopback gives every test a fresh server session.
+func startLoopback() {
+	replayDir = ""
+	InitGame()
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+	gameState.playerData = make(map[*Player]*PerPlayerState)
+	resetSession()
+}
+
+// stepServer simulates d on the server's game.
+func stepServer(d time.Duration) {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+	gameState.game.StepTo(gameState.game.Tick() + game.Tick(d/game.TickDuration))
+}
+
+func serverPos(player game.Player) game.MapPosition {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+	for _, ps := range gameState.game.Snapshot().Players {
+		if ps.Player == player {
+			return ps.Pos.MapPosition()
+		}
+	}
+	return game.MapPosition{}
+}
+
+var moves = map[game.Direction]game.ActionType{
+	game.DirNorth: game.ActionMoveNorth,
+	game.DirEast:  game.ActionMoveEast,
+	game.DirSouth: game.ActionMoveSouth,
+	game.DirWest:  game.ActionMoveWest,
+}
+
+// freeMove finds a move the player can make right away.
+func freeMove(t *testing.T, player game.Player) (game.ActionType, game.MapPosition) {
+	pos := serverPos(player)
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+	for _, dir := range []game.Direction{game.DirNorth, game.DirEast, game.DirSouth, game.DirWest} {
+		if gameState.game.IsEmpty(pos.Neighbor(dir)) {
+			return moves[dir], pos.Neighbor(dir)
+		}
+	}
+	t.Fatal("Player cannot move")
+	return game.ActionNoAction, pos
+}
+
+func TestLoopbackSession(t *testing.T) {
+	startLoopback()
+
+	human := joinLoopback(t, "anna")
+	if resp, _, _ := human.send(game.ActionPlayerReady); resp != game.ServerActionOk {
+		t.Fatal("Ready refused", resp)
+	}
+
+	ghost := joinLoopback(t, "ben")
+	if human.player == ghost.player {
+		t.Fatal("Both players got the same role")
+	}
+	if resp, _, reason := ghost.send(game.ActionPlayerReady); resp != game.ServerActionDenied || reason != game.DenyGameStarted {
+		t.Fatal("Ready after the start was not denied", resp, reason)
+	}
+
+	// the human waits until the ghost has seen the ready
+	if resp, _, _ := human.send(game.ActionLookNorth); resp != game.ServerActionWait {
+		t.Fatal("Unsynchronized action was not held back", resp)
+	}
+	if actions := ghost.update(); len(actions) != 1 || actions[0].Action != game.ActionPlayerReady {
+		t.Fatal("Ghost did not get the ready", actions)
+	}
+
+	action, target := freeMove(t, human.player)
+	resp, tick, _ := human.send(action)
+	if resp != game.ServerActionOk {
+		t.Fatal("Move refused", resp)
+	}
+	if actions := ghost.update(); len(actions) != 1 || actions[0].Action != action || actions[0].Tick != tick {
+		t.Fatal("Ghost did not get the move", actions)
+	}
+
+	gameState.dataLock.Lock()
+	walkTime := gameState.game.WalkTime()
+	gameState.dataLock.Unlock()
+	stepServer(2 * walkTime)
+	if pos := serverPos(human.player); pos != target {
+		t.Fatal("Human is at", pos, "not at", target)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"laby/game"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds of the tick duration histogram, a tick is 20ms.
+var tickBuckets = []time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	1 * time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+}
+
+type Metrics struct {
+	lock          sync.Mutex
+	actions       uint64
+	levels        uint64            // completed
+	actionsDenied map[string]uint64 // by the name of the DenyReason
+	tickCounts    []uint64          // per bucket, the last one is +Inf
+	tickSum       time.Duration
+	tickCount     uint64
+
+	bytesSent     uint64 // atomic
+	bytesReceived uint64 // atomic
+}
+
+var metrics = NewMetrics()
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		actionsDenied: make(map[string]uint64),
+		tickCounts:    make([]uint64, len(tickBuckets)+1),
+	}
+}
+
+func (m *Metrics) ActionPerformed() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.actions++
+}
+
+func (m *Metrics) LevelCompleted() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.levels++
+}
+
+// ActionDenied counts by the reason, different errors may share a message.
+func (m *Metrics) ActionDenied(reason game.DenyReason) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.actionsDenied[reason.String()]++
+}
+
+// Tick records how long the update loop took to simulate.
+func (m *Metrics) Tick(d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	bucket := sort.Search(len(tickBuckets), func(i int) bool { return d <= tickBuckets[i] })
+	m.tickCounts[bucket]++
+	m.tickSum += d
+	m.tickCount++
+}
+
+func (m *Metrics) Sent(n int) {
+	atomic.AddUint64(&m.bytesSent, uint64(n))
+}
+
+func (m *Metrics) Received(n int) {
+	atomic.AddUint64(&m.bytesReceived, uint64(n))
+}
+
+// countingConn counts the traffic of a client connection.
+type countingConn struct {
+	net.Conn
+}
+
+func NewCountingConn(conn net.Conn) net.Conn {
+	return countingConn{conn}
+}
+
+func (c countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	metrics.Received(n)
+	return n, err
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	metrics.Sent(n)
+	return n, err
+}
+
+// countingReader counts what is read from a connection that was already
+// wrapped in a buffered reader.
+type countingReader struct {
+	reader io.Reader
+}
+
+func NewCountingReader(reader io.Reader) *bufio.Reader {
+	return bufio.NewReader(countingReader{reader})
+}
+
+func (c countingReader) Read(b []byte) (int, error) {
+	n, err := c.reader.Read(b)
+	metrics.Received(n)
+	return n, err
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeMetric(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// WriteMetrics writes the metrics in the Prometheus text format.
+func WriteMetrics(w io.Writer) {
+	gameState.dataLock.Lock()
+	sessions := 0
+	if len(gameState.playerData) > 0 {
+		sessions = 1
+	}
+	players := 0
+	for player, _ := range gameState.playerData {
+		if player.connected && player.bot == nil {
+			players++
+		}
+	}
+	spectators := len(gameState.spectators)
+	gameState.dataLock.Unlock()
+
+	writeMetric(w, "laby_uptime_seconds", "gauge", "Time since the server started.")
+	fmt.Fprintf(w, "laby_uptime_seconds %g\n", time.Since(startTime).Seconds())
+
+	writeMetric(w, "laby_sessions_active", "gauge", "Sessions with at least one player.")
+	fmt.Fprintf(w, "laby_sessions_active %d\n", sessions)
+
+	writeMetric(w, "laby_connections_active", "gauge", "Open client connections.")
+	fmt.Fprintf(w, "laby_connections_active{kind=\"player\"} %d\n", players)
+	fmt.Fprintf(w, "laby_connections_active{kind=\"spectator\"} %d\n", spectators)
+
+	writeMetric(w, "laby_bytes_sent_total", "counter", "Bytes sent to clients.")
+	fmt.Fprintf(w, "laby_bytes_sent_total %d\n", atomic.LoadUint64(&metrics.bytesSent))
+
+	writeMetric(w, "laby_bytes_received_total", "counter", "Bytes received from clients.")
+	fmt.Fprintf(w, "laby_bytes_received_total %d\n", atomic.LoadUint64(&metrics.bytesReceived))
+
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+
+	writeMetric(w, "laby_actions_total", "counter", "Player actions performed.")
+	fmt.Fprintf(w, "laby_actions_total %d\n", metrics.actions)
+
+	writeMetric(w, "laby_actions_denied_total", "counter", "Player actions the game refused, by reason.")
+	reasons := make([]string, 0, len(metrics.actionsDenied))
+	for reason, _ := range metrics.actionsDenied {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "laby_actions_denied_total{reason=\"%s\"} %d\n",
+			labelEscaper.Replace(reason), metrics.actionsDenied[reason])
+	}
+
+	writeMetric(w, "laby_levels_completed_total", "counter", "Levels both players reached the goal room of.")
+	fmt.Fprintf(w, "laby_levels_completed_total %d\n", metrics.levels)
+
+	writeMetric(w, "laby_tick_duration_seconds", "histogram", "Time the update loop takes to simulate the game.")
+	var cumulative uint64
+	for i, bound := range tickBuckets {
+		cumulative += metrics.tickCounts[i]
+		fmt.Fprintf(w, "laby_tick_duration_seconds_bucket{le=\"%g\"} %d\n", bound.Seconds(), cumulative)
+	}
+	cumulative += metrics.tickCounts[len(tickBuckets)]
+	fmt.Fprintf(w, "laby_tick_duration_seconds_bucket{le=\"+Inf\"} %d\n", cumulative)
+	fmt.Fprintf(w, "laby_tick_duration_seconds_sum %g\n", metrics.tickSum.Seconds())
+	fmt.Fprintf(w, "laby_tick_duration_seconds_count %d\n", metrics.tickCount)
+}
+
+// ServeMetrics serves the metrics for Prometheus on /metrics.
+func ServeMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteMetrics(w)
+	})
+	return http.ListenAndServe(addr, mux)
+}
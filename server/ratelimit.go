@@ -0,0 +1,47 @@
+package main
+
+import (
+	"time"
+)
+
+// RateLimiter is a token bucket, it allows bursts of up to burst events and
+// refills one token per interval. It is not safe for concurrent use.
+type RateLimiter struct {
+	tokens   float64
+	burst    float64
+	interval time.Duration
+	last     time.Time
+}
+
+func NewRateLimiter(burst int, interval time.Duration) *RateLimiter {
+	return &RateLimiter{
+		tokens:   float64(burst),
+		burst:    float64(burst),
+		interval: interval,
+		last:     time.Now(),
+	}
+}
+
+func (rl *RateLimiter) Allow() bool {
+	now := time.Now()
+	rl.tokens += float64(now.Sub(rl.last)) / float64(rl.interval)
+	if rl.tokens > rl.burst {
+		rl.tokens = rl.burst
+	}
+	rl.last = now
+
+	if rl.tokens < 1 {
+		return false
+	}
+
+	rl.tokens--
+	return true
+}
+
+// Refund gives back the token of an event that did not count after all.
+func (rl *RateLimiter) Refund() {
+	rl.tokens++
+	if rl.tokens > rl.burst {
+		rl.tokens = rl.burst
+	}
+}
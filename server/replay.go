@@ -0,0 +1,66 @@
+package main
+
+import (
+	"laby/game"
+	"path/filepath"
+)
+
+var replayDir = "replays"
+
+// startRecording writes a replay of the session that just started. The
+// caller holds the data lock.
+func startRecording() {
+	stopRecording()
+	if replayDir == "" {
+		return
+	}
+
+	path := filepath.Join(replayDir, sessionId()+".jsonl")
+	replay, err := game.CreateReplay(path, gameState.game, savedPlayers())
+	if err != nil {
+		inSession(sessionLog).Error("Failed to record replay", "err", err)
+		return
+	}
+
+	inSession(sessionLog).Info("Recording replay", "file", path)
+	gameState.replay = replay
+}
+
+// stopRecording closes the replay of the current session. The caller holds
+// the data lock.
+func stopRecording() {
+	if gameState.replay == nil {
+		return
+	}
+
+	if err := gameState.replay.Close(); err != nil {
+		inSession(sessionLog).Error("Failed to close replay", "err", err)
+	}
+	gameState.replay = nil
+}
+
+// recordAction adds a performed action to the replay. A replay that cannot
+// be written is given up, the session goes on. The caller holds the data lock.
+func recordAction(tick game.Tick, player game.Player, action game.ActionType) {
+	if gameState.replay == nil {
+		return
+	}
+
+	if err := gameState.replay.Action(tick, player, action); err != nil {
+		inSession(sessionLog).Error("Failed to record action, stopping replay", "err", err)
+		stopRecording()
+	}
+}
+
+// recordRewind adds an undo or restart to the replay. The caller holds the
+// data lock.
+func recordRewind() {
+	if gameState.replay == nil {
+		return
+	}
+
+	if err := gameState.replay.Rewind(gameState.game); err != nil {
+		inSession(sessionLog).Error("Failed to record rewind, stopping replay", "err", err)
+		stopRecording()
+	}
+}
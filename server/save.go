@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"laby/game"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var saveDir = "saves"
+
+// A session is saved under a name made from the player ids of both partners,
+// the same two players find it again no matter who joins first.
+func savePath(playerIds []string) string {
+	ids := make([]string, len(playerIds))
+	copy(ids, playerIds)
+	sort.Strings(ids)
+
+	sum := sha1.Sum([]byte(strings.Join(ids, ":")))
+	return filepath.Join(saveDir, hex.EncodeToString(sum[:8])+".json")
+}
+
+// saveSession writes the running session to disk. The caller holds the data
+// lock.
+func saveSession() error {
+	if !gameState.gameStarted || len(gameState.playerData) != 2 {
+		return game.ErrNothingToSave
+	}
+	// the bot has no id to find the save with and would not come back anyway
+	for player, _ := range gameState.playerData {
+		if player.bot != nil {
+			return game.ErrBotPartner
+		}
+	}
+
+	players := savedPlayers()
+	ids := make([]string, 0, 2)
+	for _, player := range players {
+		if player.PlayerId == "" {
+			return game.ErrNoPlayerId
+		}
+		ids = append(ids, player.PlayerId)
+	}
+
+	path := savePath(ids)
+	if err := gameState.game.SaveToFile(path, players); err != nil {
+		return err
+	}
+
+	inSession(sessionLog).Info("Session saved", "file", path, "tick", gameState.game.Tick())
+	return nil
+}
+
+// savedPlayers lists who plays which role. The caller holds the data lock.
+func savedPlayers() []game.SavedPlayer {
+	players := make([]game.SavedPlayer, 0, len(gameState.playerData))
+	for player, _ := range gameState.playerData {
+		players = append(players, game.SavedPlayer{
+			Player:   player.gamePlayer,
+			Nick:     player.nick,
+			PlayerId: player.persistentId,
+		})
+	}
+	sort.Slice(players, func(i, j int) bool { return players[i].Player < players[j].Player })
+	return players
+}
+
+func SaveSession(player *Player) error {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	playerLog(sessionLog, player).Info("Save requested")
+	return saveSession()
+}
+
+// findSave returns the newest saved session playerId took part in. It reads
+// every save, the caller must not hold the data lock.
+func findSave(playerId string) (*game.SaveFile, string) {
+	if playerId == "" {
+		return nil, ""
+	}
+
+	files, err := ioutil.ReadDir(saveDir)
+	if err != nil {
+		return nil, ""
+	}
+
+	var found *game.SaveFile
+	var foundPath string
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+
+		path := filepath.Join(saveDir, file.Name())
+		save, err := game.ReadSaveFile(path)
+		if err != nil {
+			sessionLog.Warn("Skipping save", "file", path, "err", err)
+			continue
+		}
+
+		if savedRole(save, playerId) < 0 {
+			continue
+		}
+		if found == nil || save.Saved.After(found.Saved) {
+			found = save
+			foundPath = path
+		}
+	}
+
+	return found, foundPath
+}
+
+func savedRole(save *game.SaveFile, playerId string) int {
+	for _, saved := range save.Players {
+		if saved.PlayerId == playerId {
+			return int(saved.Player)
+		}
+	}
+	return -1
+}
+
+// resumeSavedSession loads the pending save once both of its players are in
+// the lobby. The caller holds the data lock.
+func resumeSavedSession() {
+	save := gameState.resume
+	if save == nil {
+		return
+	}
+
+	for player, _ := range gameState.playerData {
+		if savedRole(save, player.persistentId) != int(player.gamePlayer) {
+			playerLog(sessionLog, player).Info("Partner is not from the saved session, starting a new game")
+			gameState.resume = nil
+			return
+		}
+	}
+
+	g, err := game.LoadGame(save)
+	if err != nil {
+		inSession(sessionLog).Error("Failed to resume saved session", "err", err)
+		gameState.resume = nil
+		return
+	}
+	g.RecordHashes()
+	g.RecordEvents()
+	gameState.game = g
+
+	// the save is used up, the next save writes a new one
+	if err := os.Remove(gameState.resumePath); err != nil {
+		inSession(sessionLog).Warn("Failed to remove save", "err", err)
+	}
+	inSession(sessionLog).Info("Resumed saved session", "file", gameState.resumePath, "tick", g.Tick())
+
+	gameState.resume = nil
+	gameState.resumePath = ""
+}
@@ -1,14 +1,23 @@
 package main
 
 import (
-	"encoding/gob"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"laby/game"
-	"log"
 	"net"
+	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
+const reconnectTimeout = 60 * time.Second
+
+var peerTimeout = 10 * time.Second
+
 // var game Game = game.NewGame()
 var gameState *GameState
 var initOnce sync.Once
@@ -17,13 +26,17 @@ func InitGame() {
 	initOnce.Do(func() {
 		g, err := game.NewGame()
 		if err != nil {
-			log.Fatal("Failed to initialize game")
+			gameLog.Fatal("Failed to initialize game", "err", err)
 		}
+		g.RecordHashes()
+		g.RecordEvents()
 		gameState = &GameState{
 			dataLock:    sync.Mutex{},
 			playerData:  make(map[*Player]*PerPlayerState, 0),
+			spectators:  make(map[game.Transport]*SpectatorState),
 			game:        g,
 			gameStarted: false,
+			paused:      false,
 		}
 	})
 }
@@ -31,34 +44,103 @@ func InitGame() {
 type GameState struct {
 	dataLock    sync.Mutex
 	playerData  map[*Player]*PerPlayerState
+	spectators  map[game.Transport]*SpectatorState
 	game        *game.Game
 	gameStarted bool
+	paused      bool // a player lost the connection, waiting for the reconnect
+
+	resume     *game.SaveFile // saved session of the player in the lobby
+	resumePath string
+
+	undo     []*game.GameSnapshot // state before each of the last joint moves
+	nextUndo *game.GameSnapshot   // state before the move being made, kept once it changes the puzzle
+	pending  []pendingAction      // moves and pushes the game has not resolved yet
+	vote     *Vote
+
+	replay *game.ReplayWriter // recording of the running session
 }
 
 type Player struct {
-	conn       net.Conn
-	gamePlayer game.Player
+	conn         game.Transport
+	gamePlayer   game.Player
+	nick         string
+	persistentId string
+	token        string
+	connected    bool
+	rtt          time.Duration
+	lastSeen     time.Time
+	bot          *game.Bot // nil for people
+
+	kicked         bool        // the connection removes the player once it is closed
+	reconnectTimer *time.Timer // runs while the player is disconnected
+	disconnects    int         // tells a stale timer from the current one
 }
 
-func NewPlayer(conn net.Conn, gamePlayer game.Player) *Player {
+func NewPlayer(conn game.Transport, gamePlayer game.Player, nick, persistentId string) *Player {
 	return &Player{
-		conn:       conn,
-		gamePlayer: gamePlayer,
+		conn:         conn,
+		gamePlayer:   gamePlayer,
+		nick:         nick,
+		persistentId: persistentId,
+		token:        NewSessionToken(),
+		connected:    true,
+		rtt:          0,
+		lastSeen:     time.Now(),
+	}
+}
+
+func (p *Player) String() string {
+	return p.nick + " (" + game.RoleName(p.gamePlayer) + ")"
+}
+
+func NewSessionToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		netLog.Fatal("Failed to create session token", "err", err)
 	}
+	return hex.EncodeToString(b)
 }
 
 type PerPlayerState struct {
-	newActions []game.ActionType
+	newActions []game.TickAction
 	isReady    bool
+	wasReset   bool // session was reset, the client has not been told yet
+	wasRewound bool // undo or restart, the client has not been told yet
+
+	updateTick game.Tick // tick of the last update sent to the client
+	hashTick   game.Tick // the client was last asked for its hash on this tick
+
+	inbox        []game.Message
+	chatLimit    *RateLimiter
+	markerLimit  *RateLimiter
+	actionLimits *ActionLimits
+
+	viewCells []game.ViewCell   // cells of the last view sent with the fog on
+	blocked   []game.DenyReason // moves resolved to stay, for the next view
 }
 
 func NewPlayerState() *PerPlayerState {
 	return &PerPlayerState{
-		newActions: make([]game.ActionType, 0),
+		newActions: make([]game.TickAction, 0),
 		isReady:    false,
+		wasReset:   false,
+
+		inbox:        make([]game.Message, 0),
+		chatLimit:    NewRateLimiter(chatBurst, chatInterval),
+		markerLimit:  NewRateLimiter(markerBurst, markerInterval),
+		actionLimits: NewActionLimits(gameState.game),
 	}
 }
 
+// PlayerIsFlooding tells whether the player keeps acting faster than the game
+// allows.
+func PlayerIsFlooding(player *Player) bool {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	return gameState.playerData[player].actionLimits.Flooding()
+}
+
 func PlayerIsSynchronized(player *Player) bool {
 	gameState.dataLock.Lock()
 	defer gameState.dataLock.Unlock()
@@ -77,7 +159,7 @@ func SetPlayerSynchronized(player *Player) {
 	gameState.dataLock.Lock()
 	defer gameState.dataLock.Unlock()
 
-	gameState.playerData[player].newActions = make([]game.ActionType, 0)
+	gameState.playerData[player].newActions = make([]game.TickAction, 0)
 }
 
 func OtherPlayers(player *Player) []*Player {
@@ -94,14 +176,14 @@ func OtherPlayers(player *Player) []*Player {
 	return otherPlayers
 }
 
-func CompileData(player *Player) map[game.Player][]game.ActionType {
+func CompileData(player *Player) map[game.Player][]game.TickAction {
 	gameState.dataLock.Lock()
 	defer gameState.dataLock.Unlock()
 
-	data := make(map[game.Player][]game.ActionType, 0)
+	data := make(map[game.Player][]game.TickAction, 0)
 	for otherPlayer, state := range gameState.playerData {
 		if otherPlayer != player {
-			copyData := make([]game.ActionType, len(state.newActions))
+			copyData := make([]game.TickAction, len(state.newActions))
 			copy(copyData, state.newActions)
 			data[otherPlayer.gamePlayer] = copyData
 		}
@@ -109,16 +191,22 @@ func CompileData(player *Player) map[game.Player][]game.ActionType {
 	return data
 }
 
-func AddNewActions(player *Player, actions []game.ActionType) {
+// SetPlayerReady marks the player as ready, it returns false if the game has
+// started already.
+func SetPlayerReady(player *Player) bool {
 	gameState.dataLock.Lock()
 	defer gameState.dataLock.Unlock()
-	gameState.playerData[player].newActions = actions
+	if gameState.gameStarted {
+		return false
+	}
+	gameState.playerData[player].isReady = true
+	return true
 }
 
-func SetPlayerReady(player *Player) {
+func GameStarted() bool {
 	gameState.dataLock.Lock()
 	defer gameState.dataLock.Unlock()
-	gameState.playerData[player].isReady = true
+	return gameState.gameStarted
 }
 
 func AllPlayersReady() bool {
@@ -140,144 +228,696 @@ func AllPlayersReady() bool {
 // 	gameState.gameStarted = true
 // }
 
-func PerformPlayerAction(player *Player, action game.ActionType) error {
+// PerformPlayerActions performs the actions right away, before the current
+// tick is simulated, and hands them to the partner if all of them succeeded.
+// Both happen under one lock, an update never reports a tick without the
+// actions performed on it. The tick is returned so the client can do the same.
+func PerformPlayerActions(player *Player, actions []game.ActionType) (game.Tick, error) {
 	gameState.dataLock.Lock()
 	defer gameState.dataLock.Unlock()
-	return gameState.game.PerformPlayerAction(player.gamePlayer, action)
+
+	return performPlayerActions(player, actions)
+}
+
+// performPlayerActions is PerformPlayerActions for callers that hold the data
+// lock.
+func performPlayerActions(player *Player, actions []game.ActionType) (game.Tick, error) {
+	tick := gameState.game.Tick()
+	tickActions := make([]game.TickAction, 0, len(actions))
+	var err error
+	for _, action := range actions {
+		undoable := gameState.gameStarted && changesPuzzle(action)
+		playerLog(gameLog, player).Debug("Performing action", "action", action, "tick", tick)
+		var actionFailed error
+		limits := gameState.playerData[player].actionLimits
+		if !limits.Allow(action) {
+			actionFailed = game.ErrTooFast
+		} else {
+			if undoable {
+				undoPoint(tick)
+			}
+			if actionFailed = gameState.game.PerformPlayerAction(player.gamePlayer, action); actionFailed != nil {
+				limits.Refund(action)
+			}
+		}
+		if actionFailed != nil {
+			playerLog(gameLog, player).Debug("Action failed", "action", action, "tick", tick, "err", actionFailed)
+			actionDenied(player, tick, action, game.ReasonOf(actionFailed))
+			if undoable {
+				dropUndo()
+			}
+			err = actionFailed
+		} else {
+			// a move or push may still be blocked when its tick is resolved
+			if gameState.game.HasIntent(player.gamePlayer) {
+				gameState.pending = append(gameState.pending, pendingAction{player, tick, action, undoable})
+			} else {
+				actionAccepted(player, tick, action, undoable)
+			}
+			auditGameEvents(gameState.game.TakeEvents())
+			recordAction(tick, player.gamePlayer, action)
+		}
+		tickActions = append(tickActions, game.TickAction{Tick: tick, Player: player.gamePlayer, Action: action})
+	}
+
+	// with the fog on the partner does not get the actions
+	if err == nil && !fogEnabled {
+		gameState.playerData[player].newActions = tickActions
+	}
+	return tick, err
+}
+
+// pendingAction is a move or push that was passed to the game but not
+// resolved yet.
+type pendingAction struct {
+	player   *Player
+	tick     game.Tick
+	action   game.ActionType
+	undoable bool
+}
+
+// actionAccepted counts, audits and keeps the undo point of an action that
+// happened. The caller holds the data lock.
+func actionAccepted(player *Player, tick game.Tick, action game.ActionType, undoable bool) {
+	metrics.ActionPerformed()
+	auditPlayer(player, game.AuditEvent{Tick: tick, Kind: game.AuditAction, Action: action.String(),
+		Result: game.AuditAccepted})
+	if undoable {
+		keepUndo(tick)
+	}
+}
+
+// actionDenied counts and audits an action that did not happen.
+func actionDenied(player *Player, tick game.Tick, action game.ActionType, reason game.DenyReason) {
+	metrics.ActionDenied(reason)
+	auditPlayer(player, game.AuditEvent{Tick: tick, Kind: game.AuditAction, Action: action.String(),
+		Result: game.AuditDenied, Reason: reason.String()})
+}
+
+// settlePending accepts the pending actions the game has resolved since, or
+// denies them by the reason of their EventBlocked. The caller holds the data
+// lock.
+func settlePending(events []game.GameEvent) {
+	blocked := make(map[game.Player]game.DenyReason)
+	for _, e := range events {
+		if e.Kind == game.EventBlocked {
+			blocked[e.Player] = game.DenyReason(e.Id)
+		}
+	}
+
+	denied := false
+	pending := gameState.pending[:0]
+	for _, p := range gameState.pending {
+		if gameState.game.HasIntent(p.player.gamePlayer) {
+			pending = append(pending, p)
+		} else if reason, ok := blocked[p.player.gamePlayer]; ok {
+			if state, ok := gameState.playerData[p.player]; ok {
+				state.actionLimits.Refund(p.action)
+			}
+			actionDenied(p.player, p.tick, p.action, reason)
+			denied = denied || p.undoable
+		} else {
+			actionAccepted(p.player, p.tick, p.action, p.undoable)
+		}
+	}
+	gameState.pending = pending
+	if denied {
+		dropUndo()
+	}
+}
+
+// levelCompleted counts and announces the completion of the level. The
+// caller holds the data lock.
+func levelCompleted(events []game.GameEvent) {
+	for _, e := range events {
+		if e.Kind == game.EventLevelCompleted {
+			inSession(sessionLog).Info("Level completed", "tick", e.Tick)
+			metrics.LevelCompleted()
+			notice("Level completed")
+		}
+	}
+}
+
+// UpdateTick returns the current tick for an update of player and the
+// checkpoint tick the client should send its state hash for, 0 if none. The
+// client has not simulated past the checkpoint yet.
+func UpdateTick(player *Player) (game.Tick, game.Tick) {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	tick := gameState.game.Tick()
+	state := gameState.playerData[player]
+
+	hashTick := tick - tick%game.HashInterval
+	if hashTick == 0 || hashTick < state.updateTick || hashTick == state.hashTick {
+		hashTick = 0
+	} else {
+		state.hashTick = hashTick
+	}
+
+	state.updateTick = tick
+	return tick, hashTick
+}
+
+func playerIdTaken(id int) bool {
+	for player, _ := range gameState.playerData {
+		if int(player.gamePlayer) == id {
+			return true
+		}
+	}
+	return false
+}
+
+func freePlayerId() int {
+	for id := 0; id < 2; id++ {
+		if !playerIdTaken(id) {
+			return id
+		}
+	}
+
+	return -1
+}
+
+// uniqueNick makes sure the partners can tell each other apart. The caller
+// holds the data lock.
+func uniqueNick(nick string, playerId int) string {
+	if nick == "" {
+		nick = fmt.Sprintf("Player %d", playerId+1)
+	}
+
+	for other, _ := range gameState.playerData {
+		if strings.EqualFold(other.nick, nick) {
+			return fmt.Sprintf("%s %d", nick, playerId+1)
+		}
+	}
+
+	return nick
 }
 
-func GameAddPlayer(conn net.Conn) *Player {
+func GameAddPlayer(conn game.Transport, nick, persistentId string) (*Player, error) {
+	// reading the saves takes long, the game goes on meanwhile
+	save, savePath := findSave(persistentId)
+
 	gameState.dataLock.Lock()
 	defer gameState.dataLock.Unlock()
 
-	playerId := len(gameState.playerData)
+	if persistentId != "" {
+		for other, _ := range gameState.playerData {
+			if other.persistentId == persistentId {
+				return nil, errors.New("Already playing in this session")
+			}
+		}
+	}
+
+	// the first player decides which saved session may be resumed, the
+	// players get back the roles they had
+	if len(gameState.playerData) == 0 {
+		gameState.resume, gameState.resumePath = save, savePath
+	}
+
+	playerId := freePlayerId()
+	if playerId < 0 {
+		return nil, errors.New("Session full")
+	}
+
+	if gameState.resume != nil {
+		if role := savedRole(gameState.resume, persistentId); role >= 0 && !playerIdTaken(role) {
+			playerId = role
+		}
+	}
+
 	gamePlayer := gameState.game.NewPlayer(playerId)
-	newPlayer := NewPlayer(conn, gamePlayer)
+	newPlayer := NewPlayer(conn, gamePlayer, uniqueNick(nick, playerId), persistentId)
 
 	gameState.playerData[newPlayer] = NewPlayerState()
+	auditPlayer(newPlayer, game.AuditEvent{Tick: gameState.game.Tick(), Kind: game.AuditConnect,
+		Addr: conn.RemoteAddr().String()})
+
+	if botEnabled && len(gameState.playerData) == 1 {
+		addBot()
+	}
 
 	if len(gameState.playerData) == 2 {
+		startSession()
+		resumeSavedSession()
 		gameState.gameStarted = true
+		inSession(sessionLog).Info("Session started")
+		audit(game.AuditEvent{Tick: gameState.game.Tick(), Kind: game.AuditSessionStart})
+		auditRoles()
+		startRecording()
 	}
 
-	return newPlayer
+	return newPlayer, nil
 }
 
-func handleConnection(conn net.Conn) {
-	player := GameAddPlayer(conn)
-	defer func() {
-	}()
+// GameResumePlayer hands the player owning token over to the new connection.
+// An old connection that is still open is closed.
+func GameResumePlayer(conn game.Transport, token string) *Player {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	if token == "" {
+		return nil
+	}
+
+	for player, _ := range gameState.playerData {
+		if player.token != token || player.kicked {
+			continue
+		}
+
+		if player.connected {
+			player.conn.Close()
+		}
+
+		player.conn = conn
+		player.connected = true
+		player.lastSeen = time.Now()
+		if player.reconnectTimer != nil {
+			player.reconnectTimer.Stop()
+			player.reconnectTimer = nil
+		}
+		gameState.playerData[player].viewCells = nil
+		gameState.paused = !allPlayersConnected()
+		auditPlayer(player, game.AuditEvent{Tick: gameState.game.Tick(), Kind: game.AuditResume,
+			Addr: conn.RemoteAddr().String()})
+		return player
+	}
 
-	dec := gob.NewDecoder(conn)
-	enc := gob.NewEncoder(conn)
+	return nil
+}
 
-	enc.Encode(player.gamePlayer)
+func allPlayersConnected() bool {
+	for player, _ := range gameState.playerData {
+		if !player.connected {
+			return false
+		}
+	}
+	return true
+}
+
+func PlayerDisconnected(player *Player, conn game.Transport) {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	if player.conn != conn {
+		return // player already resumed on a new connection
+	}
+
+	player.connected = false
+	if player.kicked {
+		removeKicked(player)
+		return
+	}
+
+	if !gameState.gameStarted {
+		playerLog(sessionLog, player).Info("Player left the lobby")
+		auditPlayer(player, game.AuditEvent{Tick: gameState.game.Tick(), Kind: game.AuditLeave})
+		delete(gameState.playerData, player)
+		if len(gameState.playerData) == 0 {
+			gameState.resume = nil
+		}
+		resetSession()
+		return
+	}
+
+	playerLog(sessionLog, player).Info("Player disconnected, pausing session")
+	auditPlayer(player, game.AuditEvent{Tick: gameState.game.Tick(), Kind: game.AuditDisconnect})
+	gameState.paused = true
+
+	player.disconnects++
+	disconnect := player.disconnects
+	player.reconnectTimer = time.AfterFunc(reconnectTimeout, func() {
+		PlayerTimedOut(player, disconnect)
+	})
+}
+
+// PlayerTimedOut ends the session if the player is still gone after the
+// disconnect it was started for. A timer that already fired when the player
+// resumed finds a newer disconnect and does nothing.
+func PlayerTimedOut(player *Player, disconnect int) {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	if player.connected || player.disconnects != disconnect {
+		return
+	}
+	player.reconnectTimer = nil
+
+	if _, ok := gameState.playerData[player]; !ok {
+		return
+	}
+
+	playerLog(sessionLog, player).Info("Player did not come back, resetting session")
+	auditPlayer(player, game.AuditEvent{Tick: gameState.game.Tick(), Kind: game.AuditLeave, Reason: "timeout"})
+	if err := saveSession(); err == game.ErrBotPartner {
+		inSession(sessionLog).Info("Session with a bot is not saved")
+	} else if err != nil {
+		inSession(sessionLog).Error("Failed to save session", "err", err)
+	}
+	delete(gameState.playerData, player)
+	resetSession()
+}
+
+// resetSession starts a fresh game for the remaining players. The caller
+// holds the data lock.
+func resetSession() {
+	g, err := game.NewGame()
+	if err != nil {
+		gameLog.Fatal("Failed to initialize game", "err", err)
+	}
+
+	stopRecording()
+	if gameState.gameStarted {
+		inSession(sessionLog).Info("Session ended")
+		audit(game.AuditEvent{Tick: gameState.game.Tick(), Kind: game.AuditSessionEnd})
+	}
+	endSession()
+
+	g.RecordHashes()
+	g.RecordEvents()
+	gameState.game = g
+	gameState.gameStarted = false
+	gameState.paused = false
+	gameState.undo = nil
+	gameState.nextUndo = nil
+	gameState.pending = nil
+	gameState.vote = nil
+
+	for player, state := range gameState.playerData {
+		g.NewPlayer(int(player.gamePlayer))
+		state.newActions = make([]game.TickAction, 0)
+		state.isReady = false
+		state.wasReset = true
+		state.wasRewound = false
+		state.updateTick = 0
+		state.hashTick = 0
+	}
+	resetBots()
+}
+
+func PlayerSeen(player *Player) {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+	player.lastSeen = time.Now()
+}
+
+func SetPlayerLatency(player *Player, rtt time.Duration) {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+	player.rtt = rtt
+}
+
+func PlayerInfos() []game.PlayerInfo {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	infos := make([]game.PlayerInfo, 0, len(gameState.playerData))
+	for player, _ := range gameState.playerData {
+		infos = append(infos, game.PlayerInfo{
+			Player:    player.gamePlayer,
+			Nick:      player.nick,
+			Connected: player.connected,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Player < infos[j].Player })
+
+	return infos
+}
+
+func IsPaused() bool {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+	return gameState.paused
+}
+
+func SessionStatus(player *Player) game.SessionStatus {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	if state := gameState.playerData[player]; state.wasReset {
+		state.wasReset = false
+		return game.SessionReset
+	}
+
+	if state := gameState.playerData[player]; state.wasRewound {
+		state.wasRewound = false
+		return game.SessionRewound
+	}
+
+	if gameState.paused {
+		return game.SessionPartnerDisconnected
+	}
+
+	return game.SessionRunning
+}
+
+// ConnectLoopback serves a client in the same process over an in-memory
+// pipe, the returned transport is the client's end.
+func ConnectLoopback() game.Transport {
+	client, server := game.NewPipe()
+	go handleConnection(server)
+	return client
+}
+
+func handleConnection(conn game.Transport) {
+	defer conn.Close()
+
+	var join game.JoinRequest
+	conn.SetDeadline(time.Now().Add(peerTimeout))
+	if err := conn.Decode(&join); err != nil {
+		netLog.Info("Failed to decode join request", "addr", conn.RemoteAddr(), "err", err)
+		return
+	}
+
+	nick, err := game.CleanNick(join.Nick)
+	if err != nil {
+		netLog.Info("Rejecting nickname", "nick", join.Nick, "addr", conn.RemoteAddr())
+		conn.Encode(game.JoinResponse{Accepted: false, Reason: err.Error()})
+		return
+	}
+
+	if !game.ValidPlayerId(join.PlayerId) {
+		netLog.Info("Rejecting player id", "id", join.PlayerId, "addr", conn.RemoteAddr())
+		conn.Encode(game.JoinResponse{Accepted: false, Reason: "Invalid player id"})
+		return
+	}
+
+	if join.Spectator {
+		if fogEnabled {
+			netLog.Info("Rejecting spectator, the map is hidden", "nick", nick, "addr", conn.RemoteAddr())
+			conn.Encode(game.JoinResponse{Accepted: false, Reason: "Spectators are not allowed on this server"})
+			return
+		}
+		handleSpectator(conn, nick)
+		return
+	}
+
+	resumed := true
+	player := GameResumePlayer(conn, join.Token)
+	if player == nil {
+		resumed = false
+		player, err = GameAddPlayer(conn, nick, join.PlayerId)
+	}
+
+	if player == nil {
+		netLog.Info("Rejecting player", "nick", nick, "addr", conn.RemoteAddr(), "err", err)
+		conn.Encode(game.JoinResponse{Accepted: false, Reason: err.Error()})
+		return
+	}
+	defer PlayerDisconnected(player, conn)
+
+	if resumed {
+		playerLog(netLog, player).Info("Player resumed", "addr", conn.RemoteAddr())
+	} else {
+		playerLog(netLog, player).Info("Player joined", "addr", conn.RemoteAddr())
+	}
+
+	conn.Encode(game.JoinResponse{
+		Accepted: true,
+		Resumed:  resumed,
+		Player:   player.gamePlayer,
+		Token:    player.token,
+		Nick:     player.nick,
+		Fog:      fogEnabled,
+		Level:    joinLevel(),
+	})
 
 	var req game.ClientRequest
 	for {
-		err := dec.Decode(&req)
+		conn.SetDeadline(time.Now().Add(peerTimeout))
+		err := conn.Decode(&req)
 		if err != nil {
-			log.Fatal("Failed to decode client req")
+			playerLog(netLog, player).Info("Connection lost", "err", err)
+			return
+		}
+		if !req.Valid() {
+			playerLog(netLog, player).Warn("Disconnecting client, unknown request", "request", req)
+			return
 		}
+		if fogHides(req) {
+			playerLog(netLog, player).Warn("Disconnecting client, request not allowed with the fog on", "request", req)
+			return
+		}
+		PlayerSeen(player)
 
 		switch req {
-		case game.ClientReqGameState:
-			newPlayer := false
-			var theOtherPlayer *Player = nil
-			for otherPlayer, _ := range gameState.playerData {
-				if otherPlayer != player {
-					newPlayer = true
-					theOtherPlayer = otherPlayer
+		case game.ClientReqPlayers:
+			conn.Encode(PlayerInfos())
+		case game.ClientReqPing:
+			var ping game.Ping
+			if err := conn.Decode(&ping); err != nil {
+				playerLog(netLog, player).Warn("Failed to decode ping", "err", err)
+				return
+			}
+			if ping.LastRTT < 0 {
+				playerLog(netLog, player).Warn("Disconnecting client, invalid ping", "rtt", ping.LastRTT)
+				return
+			}
+			SetPlayerLatency(player, ping.LastRTT)
+			conn.Encode(game.Pong{Sent: ping.Sent})
+		case game.ClientReqMessage:
+			var msg game.Message
+			if err := conn.Decode(&msg); err != nil {
+				playerLog(netLog, player).Warn("Failed to decode message", "err", err)
+				return
+			}
+			if !msg.Kind.Valid() {
+				playerLog(netLog, player).Warn("Disconnecting client, unknown message kind", "kind", msg.Kind)
+				return
+			}
+			if err := PostMessage(player, msg); err != nil {
+				playerLog(netLog, player).Debug("Message denied", "err", err)
+				conn.Encode(game.ServerActionDenied)
+			} else {
+				conn.Encode(game.ServerActionOk)
+			}
+		case game.ClientReqMessages:
+			conn.Encode(TakeMessages(player))
+		case game.ClientReqSnapshot:
+			snapshot, status := GameSnapshot()
+			conn.Encode(snapshot)
+			conn.Encode(status)
+		case game.ClientReqVote:
+			var kind game.VoteKind
+			if err := conn.Decode(&kind); err != nil {
+				playerLog(netLog, player).Warn("Failed to decode vote", "err", err)
+				return
+			}
+			if !kind.Valid() {
+				playerLog(netLog, player).Warn("Disconnecting client, unknown vote", "kind", kind)
+				return
+			}
+			if err := CastVote(player, kind); err != nil {
+				playerLog(netLog, player).Debug("Vote denied", "err", err)
+				conn.Encode(game.ServerActionDenied)
+			} else {
+				conn.Encode(game.ServerActionOk)
+			}
+		case game.ClientReqSave:
+			if err := SaveSession(player); err != nil {
+				if _, ok := err.(*game.ActionError); ok {
+					playerLog(sessionLog, player).Info("Save denied", "err", err)
+				} else {
+					inSession(sessionLog).Error("Failed to save session", "err", err)
 				}
+				conn.Encode(game.ServerActionDenied)
+				conn.Encode(game.ReasonOf(err))
+			} else {
+				conn.Encode(game.ServerActionOk)
 			}
 
-			if newPlayer {
-				enc.Encode(true) // player joined
-				enc.Encode(theOtherPlayer.gamePlayer)
-				enc.Encode(gameState.gameStarted)
+		case game.ClientReqGameState:
+			otherPlayers := OtherPlayers(player)
+
+			if len(otherPlayers) > 0 {
+				conn.Encode(true) // player joined
+				conn.Encode(otherPlayers[0].gamePlayer)
+				conn.Encode(GameStarted())
 			} else {
-				enc.Encode(false) // no player joined
+				conn.Encode(false) // no player joined
 			}
 		case game.ClientReqSendAction:
 			var actions []game.ActionType = make([]game.ActionType, 0, 100)
 			var numActions int
 
-			dec.Decode(&numActions)
+			err = conn.Decode(&numActions)
 			if err != nil {
-				log.Fatal("Failed to decode action length")
+				playerLog(netLog, player).Warn("Failed to decode action length", "err", err)
+				return
 			}
 
-			if numActions > 1 {
-				// log.Fatal("Only one action per update supported")
+			if numActions < 0 || numActions > maxRequestActions {
+				playerLog(netLog, player).Warn("Disconnecting client, invalid number of actions", "actions", numActions)
+				return
 			}
 
 			for i := 0; i < numActions; i++ {
 				var action game.ActionType
-				err = dec.Decode(&action)
+				err = conn.Decode(&action)
 				if err != nil {
-					log.Fatal("Failed to decode actions")
+					playerLog(netLog, player).Warn("Failed to decode actions", "err", err)
+					return
+				}
+				if !action.Valid() {
+					playerLog(netLog, player).Warn("Disconnecting client, unknown action", "action", action)
+					return
 				}
 
 				actions = append(actions, action)
 			}
 
 			actionDenied := false
-			if PlayerIsSynchronized(player) {
+			if IsPaused() {
+				conn.Encode(game.ServerActionWait)
+			} else if PlayerIsSynchronized(player) {
 				// log.Println(actions)
 				for _, action := range actions {
-					if action == game.ActionPlayerReady {
-						if gameState.gameStarted {
-							actionDenied = true
-						} else {
-							SetPlayerReady(player)
-						}
+					if action == game.ActionPlayerReady && !SetPlayerReady(player) {
+						actionDenied = true
 					}
 				}
 
 				if actionDenied {
-					log.Println("Action denied from player", player)
-					enc.Encode(game.ServerActionDenied)
+					playerLog(netLog, player).Debug("Action denied, game already started")
+					conn.Encode(game.ServerActionDenied)
+					conn.Encode(game.DenyGameStarted)
 				} else {
 					// update server game state
-					actionNotPossible := false
-					for _, action := range actions {
-						actionFailed := PerformPlayerAction(player, action)
-						log.Println("performing player action", player, action)
-						if actionFailed != nil {
-							log.Println(actionFailed)
-							actionNotPossible = true
+					tick, actionFailed := PerformPlayerActions(player, actions)
+					if actionFailed != nil {
+						conn.Encode(game.ServerActionDenied)
+						conn.Encode(game.ReasonOf(actionFailed))
+						if PlayerIsFlooding(player) {
+							playerLog(netLog, player).Warn("Disconnecting client, sending actions too fast")
+							return
 						}
-					}
-
-					if actionNotPossible {
-						enc.Encode(game.ServerActionDenied)
 					} else {
-						copyActions := make([]game.ActionType, len(actions))
-						copy(copyActions, actions)
-						AddNewActions(player, copyActions)
-
 						// log.Println("Action ok from player", player)
-						enc.Encode(game.ServerActionOk)
+						conn.Encode(game.ServerActionOk)
+						conn.Encode(tick)
 					}
 				}
 			} else {
-				log.Println("Player not synchronized")
-				enc.Encode(game.ServerActionWait)
+				playerLog(netLog, player).Debug("Player not synchronized")
+				conn.Encode(game.ServerActionWait)
 				// ignore
 			}
 
 		case game.ClientReqUpdate:
-			var data map[game.Player][]game.ActionType = CompileData(player)
+			// read the tick first, actions compiled afterwards can only be on
+			// the same or a later tick
+			tick, hashTick := UpdateTick(player)
+			var data map[game.Player][]game.TickAction = CompileData(player)
 			if len(data) > 1 {
-				log.Fatal("To many players?")
+				gameLog.Fatal("Too many players")
 			}
-			enc.Encode(len(data))
+			conn.Encode(len(data))
 			for otherPlayer, actions := range data {
-				enc.Encode(otherPlayer)
-				enc.Encode(len(actions))
+				conn.Encode(otherPlayer)
+				conn.Encode(len(actions))
 				for _, action := range actions {
-					log.Println("Send: ", otherPlayer, action)
-					enc.Encode(action)
+					playerLog(netLog, player).Debug("Sending action", "from", otherPlayer, "action", action.Action, "tick", action.Tick)
+					conn.Encode(action)
 				}
 			}
 
@@ -287,13 +927,30 @@ func handleConnection(conn net.Conn) {
 				SetPlayerSynchronized(otherPlayer)
 			}
 
-		default:
-			log.Println("Unknown command from client")
+			conn.Encode(SessionStatus(player))
+			conn.Encode(tick)
+			conn.Encode(hashTick)
+		case game.ClientReqView:
+			conn.Encode(SessionStatus(player))
+			conn.Encode(PlayerView(player))
+		case game.ClientReqHash:
+			var stateHash game.StateHash
+			if err := conn.Decode(&stateHash); err != nil {
+				playerLog(netLog, player).Warn("Failed to decode state hash", "err", err)
+				return
+			}
+
+			if snapshot := CheckStateHash(player, stateHash); snapshot != nil {
+				conn.Encode(true)
+				conn.Encode(snapshot)
+			} else {
+				conn.Encode(false)
+			}
+
 		}
 
 		// time.Sleep(time.Millisecond * 50)
 	}
-	time.Sleep(100)
 }
 
 // var (
@@ -310,36 +967,127 @@ func UpdateGame() {
 		dt := current.Sub(last)
 		last = current
 
-		gameState.game.Update(dt)
+		if !gameState.paused {
+			start := time.Now()
+			gameState.game.Update(dt)
+			metrics.Tick(time.Since(start))
+			events := gameState.game.TakeEvents()
+			auditGameEvents(events)
+			noteBlocked(events)
+			settlePending(events)
+			levelCompleted(events)
+		}
 		gameState.dataLock.Unlock()
 
-		time.Sleep(50 * time.Millisecond)
+		time.Sleep(game.TickDuration)
+	}
+}
+
+func ServerInfo(cfg *Config) game.ServerInfo {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	return game.ServerInfo{
+		Name:    cfg.Name,
+		Port:    cfg.Port,
+		Players: len(gameState.playerData),
+		Started: gameState.gameStarted,
 	}
 }
 
 func main() {
 	var err error
-	log.SetFlags(log.Llongfile)
 
+	cfg, err := ParseConfig()
+	if err != nil {
+		sessionLog.Fatal("Failed to read config", "err", err)
+	}
+	if err := game.SetLogLevels(cfg.LogLevel); err != nil {
+		sessionLog.Fatal("Failed to set log levels", "err", err)
+	}
+	if cfg.AuditDir != "" {
+		if auditLog, err = OpenAuditLog(cfg.AuditDir); err != nil {
+			sessionLog.Fatal("Failed to open audit log", "err", err)
+		}
+	}
+	peerTimeout = cfg.PeerTimeout()
+	saveDir = cfg.SaveDir
+	replayDir = cfg.ReplayDir
+	botEnabled = cfg.Bot
+	fogEnabled = cfg.Fog
+
+	level, err := game.ReadLevelFile(cfg.Level)
+	if err != nil {
+		sessionLog.Fatal("Failed to read level", "err", err)
+	}
+	if err := game.LoadLevel(level); err != nil {
+		sessionLog.Fatal("Failed to load level", "err", err)
+	}
 	InitGame()
 
 	go UpdateGame()
+	go LogNetworkHealth(cfg.HealthLogInterval())
+	if botEnabled {
+		go RunBots()
+	}
 	// if game, err = NewGame(); err != nil {
 	// 	log.Fatal(err)
 	// }
 
+	if cfg.Discovery {
+		go func() {
+			err := game.ServeDiscovery(cfg.DiscoveryPort, func() game.ServerInfo {
+				return ServerInfo(cfg)
+			})
+			netLog.Error("LAN discovery stopped", "err", err)
+		}()
+	}
+
+	if cfg.WebSocketPort > 0 {
+		go func() {
+			netLog.Info("WebSocket clients on", "addr", cfg.WebSocketAddr(), "path", "/laby")
+			err := ServeWebSocket(cfg.WebSocketAddr(), cfg.WebSocketOrigins)
+			netLog.Error("WebSocket listener stopped", "err", err)
+		}()
+	}
+
+	if cfg.AdminPort > 0 {
+		token := cfg.AdminToken
+		if token == "" {
+			token = NewSessionToken()
+		}
+		go func() {
+			netLog.Info("Admin API on", "addr", cfg.AdminAddr(), "token", token)
+			err := ServeAdmin(cfg.AdminAddr(), token, cfg)
+			netLog.Error("Admin API stopped", "err", err)
+		}()
+	}
+
+	if cfg.MetricsPort > 0 {
+		go func() {
+			netLog.Info("Metrics on", "addr", cfg.MetricsAddr())
+			err := ServeMetrics(cfg.MetricsAddr())
+			netLog.Error("Metrics stopped", "err", err)
+		}()
+	}
+
+	if cfg.Console {
+		go RunConsole(os.Stdin, os.Stdout, cfg)
+	}
+
 	// go func() {
-	listen, err := net.Listen("tcp", ":8001")
+	listen, err := net.Listen("tcp", cfg.ListenAddr())
 	if err != nil {
-		log.Fatal(err)
+		netLog.Fatal("Failed to listen", "addr", cfg.ListenAddr(), "err", err)
 	}
+	netLog.Info("Listening on", "addr", cfg.ListenAddr())
 	for {
 		conn, err := listen.Accept()
 		if err != nil {
-			log.Println(err)
+			netLog.Warn("Failed to accept connection", "err", err)
 			continue
 		}
-		go handleConnection(conn)
+		go handleConnection(game.NewLimitedGobTransport(NewCountingConn(conn), maxClientMessage))
 	}
 	// }()
 }
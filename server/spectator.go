@@ -0,0 +1,108 @@
+package main
+
+import (
+	"laby/game"
+	"time"
+)
+
+type SpectatorState struct {
+	inbox []game.Message
+}
+
+func AddSpectator(conn game.Transport) {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+	gameState.spectators[conn] = &SpectatorState{
+		inbox: make([]game.Message, 0),
+	}
+}
+
+func RemoveSpectator(conn game.Transport) {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+	delete(gameState.spectators, conn)
+}
+
+func GameSnapshot() (*game.GameSnapshot, game.SessionStatus) {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	status := game.SessionRunning
+	if gameState.paused {
+		status = game.SessionPartnerDisconnected
+	}
+
+	return gameState.game.Snapshot(), status
+}
+
+// handleSpectator serves a connection that watches the session. Spectators
+// get the full game state on every request, they can read the chat but may
+// not send actions or messages.
+func handleSpectator(conn game.Transport, nick string) {
+	AddSpectator(conn)
+	defer RemoveSpectator(conn)
+
+	inSession(netLog).Info("Spectator joined", "nick", nick, "addr", conn.RemoteAddr())
+	observer := game.Observer
+	audit(game.AuditEvent{Kind: game.AuditConnect, Player: &observer, Nick: nick, Addr: conn.RemoteAddr().String()})
+
+	conn.Encode(game.JoinResponse{
+		Accepted:  true,
+		Spectator: true,
+		Player:    game.Observer,
+		Nick:      nick,
+		Level:     game.CurrentLevel(),
+	})
+
+	var req game.ClientRequest
+	for {
+		conn.SetDeadline(time.Now().Add(peerTimeout))
+		if err := conn.Decode(&req); err != nil {
+			inSession(netLog).Info("Spectator left", "nick", nick, "addr", conn.RemoteAddr(), "err", err)
+			return
+		}
+
+		switch req {
+		case game.ClientReqPing:
+			var ping game.Ping
+			if err := conn.Decode(&ping); err != nil {
+				return
+			}
+			conn.Encode(game.Pong{Sent: ping.Sent})
+		case game.ClientReqPlayers:
+			conn.Encode(PlayerInfos())
+		case game.ClientReqSnapshot:
+			snapshot, status := GameSnapshot()
+			conn.Encode(snapshot)
+			conn.Encode(status)
+		case game.ClientReqSendAction:
+			var numActions int
+			if err := conn.Decode(&numActions); err != nil {
+				return
+			}
+			if numActions < 0 || numActions > maxRequestActions {
+				netLog.Warn("Disconnecting spectator, invalid number of actions", "nick", nick, "actions", numActions)
+				return
+			}
+			for i := 0; i < numActions; i++ {
+				var action game.ActionType
+				if err := conn.Decode(&action); err != nil {
+					return
+				}
+			}
+			conn.Encode(game.ServerActionDenied)
+			conn.Encode(game.DenyOther)
+		case game.ClientReqMessage:
+			var msg game.Message
+			if err := conn.Decode(&msg); err != nil {
+				return
+			}
+			conn.Encode(game.ServerActionDenied)
+		case game.ClientReqMessages:
+			conn.Encode(TakeSpectatorMessages(conn))
+		default:
+			netLog.Warn("Disconnecting spectator, unknown request", "nick", nick, "request", req)
+			return
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"laby/game"
+	"time"
+)
+
+const (
+	maxUndo     = 20
+	voteTimeout = 15 * time.Second
+)
+
+// Vote is an undo or restart one player asked for, it happens once the
+// partner agrees.
+type Vote struct {
+	kind    game.VoteKind
+	voters  map[*Player]bool
+	started time.Time
+}
+
+func voteName(kind game.VoteKind) string {
+	if kind == game.VoteRestart {
+		return "restart the level"
+	}
+	return "undo the last move"
+}
+
+// changesPuzzle tells which actions get an undo point, looking around does not.
+func changesPuzzle(action game.ActionType) bool {
+	switch action {
+	case game.ActionMoveNorth, game.ActionMoveWest, game.ActionMoveSouth, game.ActionMoveEast, game.ActionAction:
+		return true
+	}
+	return false
+}
+
+// sameMove tells whether a change in tick belongs to the move that started
+// in the tick of snapshot: the partners move together if they start within
+// one walk. The caller holds the data lock.
+func sameMove(snapshot *game.GameSnapshot, tick game.Tick) bool {
+	span := game.Tick(gameState.game.WalkTime() / game.TickDuration)
+	return snapshot != nil && tick >= snapshot.Tick && tick-snapshot.Tick < span
+}
+
+// undoPoint takes the state before a change to the puzzle in tick, unless
+// the change belongs to a move that has one already. The caller holds the
+// data lock.
+func undoPoint(tick game.Tick) {
+	if n := len(gameState.undo); n > 0 && sameMove(gameState.undo[n-1], tick) {
+		return
+	}
+	if sameMove(gameState.nextUndo, tick) {
+		return
+	}
+	gameState.nextUndo = gameState.game.Snapshot()
+}
+
+// keepUndo adds the pending undo point to the history once a change to the
+// puzzle in tick happened, the oldest one is dropped once the history is
+// full. The caller holds the data lock.
+func keepUndo(tick game.Tick) {
+	snapshot := gameState.nextUndo
+	if !sameMove(snapshot, tick) {
+		return
+	}
+	gameState.nextUndo = nil
+
+	gameState.undo = append(gameState.undo, snapshot)
+	if len(gameState.undo) > maxUndo {
+		gameState.undo = gameState.undo[len(gameState.undo)-maxUndo:]
+	}
+}
+
+// dropUndo forgets the pending undo point after a change to the puzzle was
+// denied, unless a move or push still waiting to be resolved may keep it.
+// A later change must not go back to a state from before the denied one. The
+// caller holds the data lock.
+func dropUndo() {
+	for _, p := range gameState.pending {
+		if p.undoable {
+			return
+		}
+	}
+	gameState.nextUndo = nil
+}
+
+// notice tells everybody in the session about a vote. The caller holds the
+// data lock.
+func notice(text string) {
+	msg := game.Message{Kind: game.MessageChat, From: game.Observer, Nick: "*", Text: text}
+	for _, state := range gameState.playerData {
+		state.inbox = deliver(state.inbox, msg)
+	}
+	for _, spectator := range gameState.spectators {
+		spectator.inbox = deliver(spectator.inbox, msg)
+	}
+}
+
+// CastVote records that player wants an undo or a restart and carries it out
+// once every player has voted for it.
+func CastVote(player *Player, kind game.VoteKind) error {
+	gameState.dataLock.Lock()
+	defer gameState.dataLock.Unlock()
+
+	if !gameState.gameStarted || gameState.paused {
+		return errors.New("Game is not running")
+	}
+
+	switch kind {
+	case game.VoteUndo:
+		if len(gameState.undo) == 0 {
+			return errors.New("Nothing to undo")
+		}
+	case game.VoteRestart:
+	default:
+		return errors.New("Unknown vote")
+	}
+
+	vote := gameState.vote
+	if vote == nil || vote.kind != kind || time.Since(vote.started) > voteTimeout {
+		vote = &Vote{kind: kind, voters: make(map[*Player]bool), started: time.Now()}
+		gameState.vote = vote
+	}
+	if vote.voters[player] {
+		return nil
+	}
+	vote.voters[player] = true
+
+	// bots agree to whatever their partner wants
+	for other, _ := range gameState.playerData {
+		if other.bot == nil && !vote.voters[other] {
+			notice(player.nick + " wants to " + voteName(kind) + ", press the same key to agree")
+			return nil
+		}
+	}
+
+	gameState.vote = nil
+	if kind == game.VoteRestart {
+		return restartLevel()
+	}
+	return undoLastMove()
+}
+
+func undoLastMove() error {
+	snapshot := gameState.undo[len(gameState.undo)-1]
+	if err := gameState.game.Rewind(snapshot); err != nil {
+		return err
+	}
+	gameState.undo = gameState.undo[:len(gameState.undo)-1]
+	gameState.nextUndo = nil
+	gameState.pending = nil
+
+	inSession(sessionLog).Info("Undid the last move", "tick", gameState.game.Tick())
+	audit(game.AuditEvent{Tick: gameState.game.Tick(), Kind: game.AuditUndo})
+	rewound()
+	recordRewind()
+	notice("Last move undone")
+	return nil
+}
+
+func restartLevel() error {
+	g, err := game.NewGame()
+	if err != nil {
+		return err
+	}
+	for player, _ := range gameState.playerData {
+		g.NewPlayer(int(player.gamePlayer))
+	}
+
+	if err := gameState.game.Rewind(g.Snapshot()); err != nil {
+		return err
+	}
+	gameState.undo = nil
+	gameState.nextUndo = nil
+	gameState.pending = nil
+
+	inSession(sessionLog).Info("Restarted the level", "tick", gameState.game.Tick())
+	audit(game.AuditEvent{Tick: gameState.game.Tick(), Kind: game.AuditRestart})
+	rewound()
+	recordRewind()
+	notice("Level restarted")
+	return nil
+}
+
+// rewound drops the actions the partners have not seen yet, they belong to
+// the state that is gone. Every client fetches the new state.
+func rewound() {
+	for _, state := range gameState.playerData {
+		state.newActions = make([]game.TickAction, 0)
+		state.wasRewound = true
+	}
+	resetBots()
+}
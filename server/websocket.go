@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Just enough of RFC 6455 for the game protocol: one JSON encoded protocol
+// value per text message. Browsers send the page's origin, only the pages
+// in the allow-list may open a session. Clients that are not browsers send
+// no origin.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const maxWebSocketMessage = 64 * 1024
+
+const maxControlPayload = 125 // RFC 6455 5.5
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+type WebSocketConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	writeLock sync.Mutex
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h[http.CanonicalHeaderKey(name)] {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func allowedOrigin(r *http.Request, origins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range origins {
+		if strings.EqualFold(strings.TrimSpace(allowed), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpgradeWebSocket performs the opening handshake and takes over the
+// connection from the http server.
+func UpgradeWebSocket(w http.ResponseWriter, r *http.Request, origins []string) (*WebSocketConn, error) {
+	if r.Method != "GET" ||
+		!headerHasToken(r.Header, "Connection", "upgrade") ||
+		!headerHasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Expected a WebSocket handshake", http.StatusBadRequest)
+		return nil, errors.New("Not a WebSocket handshake")
+	}
+
+	if !allowedOrigin(r, origins) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return nil, errors.New("Origin not allowed: " + r.Header.Get("Origin"))
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("Unsupported WebSocket version")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("Missing WebSocket key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("Connection cannot be hijacked")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha1.Sum([]byte(key + websocketGUID))
+	accept := base64.StdEncoding.EncodeToString(hash[:])
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + accept + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &WebSocketConn{
+		conn:   NewCountingConn(conn),
+		reader: NewCountingReader(rw.Reader),
+	}, nil
+}
+
+func (ws *WebSocketConn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(ws.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if !masked {
+		return false, 0, nil, errors.New("Unmasked client frame")
+	}
+
+	if length > maxWebSocketMessage {
+		return false, 0, nil, errors.New("WebSocket frame too large")
+	}
+
+	if opcode&0x8 != 0 {
+		if !fin {
+			return false, 0, nil, errors.New("Fragmented WebSocket control frame")
+		}
+		if length > maxControlPayload {
+			return false, 0, nil, errors.New("WebSocket control frame too large")
+		}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+func (ws *WebSocketConn) writeFrame(opcode byte, payload []byte) error {
+	ws.writeLock.Lock()
+	defer ws.writeLock.Unlock()
+
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|opcode)
+
+	length := len(payload)
+	switch {
+	case length < 126:
+		frame = append(frame, byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 126, byte(length>>8), byte(length))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(length))
+		frame = append(frame, 127)
+		frame = append(frame, ext[:]...)
+	}
+
+	frame = append(frame, payload...)
+	_, err := ws.conn.Write(frame)
+	return err
+}
+
+// ReadMessage returns the next data message. Control frames are answered on
+// the way.
+func (ws *WebSocketConn) ReadMessage() ([]byte, error) {
+	message := make([]byte, 0)
+	started := false
+
+	for {
+		fin, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case wsOpText, wsOpBinary, wsOpContinuation:
+			if started == (opcode != wsOpContinuation) {
+				return nil, errors.New("Unexpected WebSocket continuation")
+			}
+			started = true
+
+			message = append(message, payload...)
+			if len(message) > maxWebSocketMessage {
+				return nil, errors.New("WebSocket message too large")
+			}
+
+			if fin {
+				return message, nil
+			}
+		case wsOpPing:
+			if err := ws.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+		case wsOpPong:
+		case wsOpClose:
+			ws.writeFrame(wsOpClose, nil)
+			return nil, io.EOF
+		default:
+			return nil, errors.New("Unknown WebSocket opcode")
+		}
+	}
+}
+
+func (ws *WebSocketConn) WriteMessage(message []byte) error {
+	return ws.writeFrame(wsOpText, message)
+}
+
+func (ws *WebSocketConn) Close() error {
+	ws.writeFrame(wsOpClose, nil)
+	return ws.conn.Close()
+}
+
+// JSONTransport speaks the game protocol over a WebSocket, every protocol
+// value is sent as its own JSON text message.
+type JSONTransport struct {
+	ws *WebSocketConn
+}
+
+func NewJSONTransport(ws *WebSocketConn) *JSONTransport {
+	return &JSONTransport{
+		ws: ws,
+	}
+}
+
+func (jt *JSONTransport) Encode(v interface{}) error {
+	message, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return jt.ws.WriteMessage(message)
+}
+
+func (jt *JSONTransport) Decode(v interface{}) error {
+	message, err := jt.ws.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(message, v)
+}
+
+func (jt *JSONTransport) SetDeadline(t time.Time) error {
+	return jt.ws.conn.SetDeadline(t)
+}
+
+func (jt *JSONTransport) RemoteAddr() net.Addr {
+	return jt.ws.conn.RemoteAddr()
+}
+
+func (jt *JSONTransport) Close() error {
+	return jt.ws.Close()
+}
+
+func ServeWebSocket(addr string, origins []string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/laby", func(w http.ResponseWriter, r *http.Request) {
+		ws, err := UpgradeWebSocket(w, r, origins)
+		if err != nil {
+			netLog.Info("WebSocket handshake failed", "addr", r.RemoteAddr, "err", err)
+			return
+		}
+
+		handleConnection(NewJSONTransport(ws))
+	})
+	return http.ListenAndServe(addr, mux)
+}